@@ -7,3 +7,77 @@ func (l *Light) GetState() LightProperties {
 	l.stateMutex.Unlock()
 	return ls
 }
+
+// GetID returns the Yeelight device ID of the light, it's empty if it isn't known
+func (l *Light) GetID() string {
+	l.describeMutex.Lock()
+	id := l.ID
+	l.describeMutex.Unlock()
+	return id
+}
+
+// GetModel returns the Yeelight model of the light, it's empty if it isn't known
+func (l *Light) GetModel() string {
+	l.describeMutex.Lock()
+	model := l.Model
+	l.describeMutex.Unlock()
+	return model
+}
+
+// Describe fills in the ID, the model and the supported methods of the light from its advertisement,
+// unless they're known already
+func (l *Light) Describe(ad Advertisement) {
+	l.describeMutex.Lock()
+	defer l.describeMutex.Unlock()
+
+	if l.ID == "" {
+		l.ID = ad.ID
+	}
+	if l.Model == "" {
+		l.Model = ad.Model
+	}
+	if len(l.Support) == 0 {
+		l.Support = ad.Support
+	}
+}
+
+// GetHost returns the address the light is currently reachable at
+func (l *Light) GetHost() string {
+	l.connMutex.Lock()
+	host := l.Host
+	l.connMutex.Unlock()
+	return host
+}
+
+// SetHost changes the address of the light, the connection to the old address is closed
+func (l *Light) SetHost(host string) {
+	l.connMutex.Lock()
+	defer l.connMutex.Unlock()
+
+	if l.Host == host {
+		return
+	}
+	l.Host = host
+
+	// readLoop of the old connection exits, and RefreshDaemon connects to the new address
+	if l.conn != nil {
+		l.dropConnLocked(l.conn)
+	}
+}
+
+// MarshalYAML lets the config be saved while the light is in use, the fields changed by discovery are read under
+// their mutexes
+func (l *Light) MarshalYAML() (interface{}, error) {
+	l.describeMutex.Lock()
+	defer l.describeMutex.Unlock()
+
+	return Light{
+		Host:       l.GetHost(),
+		Name:       l.Name,
+		ID:         l.ID,
+		Model:      l.Model,
+		Support:    l.Support,
+		Music:      l.Music,
+		Transition: l.Transition,
+	}, nil
+}
@@ -0,0 +1,164 @@
+package api
+
+import (
+	"context"
+	"fmt"
+)
+
+// Change is the state the main light should end up in, the nil fields are left as they are. At most one
+// of Ct, RGB, Hue and Sat (the latter two count as one) and Flow can be set.
+type Change struct {
+	On     *bool
+	Bright *uint8  // (range 1 - 100)
+	Ct     *uint16 // (unit: Kelvin)
+	RGB    *uint32 // (range 0 - 16777215)
+	Hue    *uint16 // (range 0 - 359), the saturation of the light is kept if Sat is nil
+	Sat    *uint8  // (range 0 - 100), the hue of the light is kept if Hue is nil
+	Flow   *Flow
+
+	// Transition is used by the commands supporting one, the one of the light is used if it's the zero value
+	Transition Transition
+}
+
+/*
+Apply changes the main light to the state in one step, sending as few commands as it can:
+
+  - turning the light off ignores the other fields, since a light that is off can't be changed
+  - nothing is sent if nothing is changed, or if the light is on already and nothing else is changed
+  - a light that is off, or is changed without a transition ("sudden" effect), is changed using set_scene if it's supported,
+    which turns it on right in the new color and brightness
+  - otherwise the color and the brightness are changed by separate commands, whose transitions run at the same time
+*/
+func (l *Light) Apply(change Change) error {
+	return l.ApplyCtx(context.Background(), change)
+}
+
+// ApplyCtx is like Apply, but it gives up when ctx is done
+func (l *Light) ApplyCtx(ctx context.Context, change Change) error {
+	transition := change.Transition
+	if transition == (Transition{}) {
+		transition = l.Transition
+	}
+	if transition == (Transition{}) {
+		transition = DefaultTransition
+	}
+	err := transition.Validate()
+	if err != nil {
+		return fmt.Errorf("Apply() failed: %v", err)
+	}
+	effect, duration := transition.Params()
+
+	if change.On != nil && !*change.On {
+		return l.SetPowerCtx(ctx, "off", effect, duration, "")
+	}
+
+	colors := 0
+	for _, set := range []bool{change.Ct != nil, change.RGB != nil, change.Hue != nil || change.Sat != nil, change.Flow != nil} {
+		if set {
+			colors++
+		}
+	}
+	if colors > 1 {
+		return fmt.Errorf("Apply() failed: only one of ct, rgb, hsv and flow can be changed at once")
+	}
+	if change.Flow != nil && change.Bright != nil {
+		return fmt.Errorf("Apply() failed: the brightness is set by the steps of the flow")
+	}
+
+	state := l.GetState()
+	if change.Ct != nil {
+		minCt, maxCt := l.CtRange()
+		if *change.Ct < minCt || *change.Ct > maxCt {
+			return fmt.Errorf("Apply() failed: ct_value out of range (%v - %v)", minCt, maxCt)
+		}
+	}
+	if (change.Hue != nil) != (change.Sat != nil) {
+		hue, sat := state.Hue, state.Sat
+		if change.Hue != nil {
+			hue = *change.Hue
+		} else {
+			sat = *change.Sat
+		}
+		change.Hue, change.Sat = &hue, &sat
+	}
+
+	// e.g. {} or just a transition, which mustn't turn on a light that is off
+	if colors == 0 && change.Bright == nil && (change.On == nil || state.On) {
+		return nil
+	}
+
+	// a light turned on (or changed) by set_scene needs no other command
+	if l.Supports("set_scene") && (!state.On || effect == "sudden") {
+		if scene := sceneOf(change, state); scene != nil {
+			return l.SetSceneCtx(ctx, scene)
+		}
+	}
+
+	if !state.On {
+		err = l.SetPowerCtx(ctx, "on", effect, duration, "")
+		if err != nil {
+			return err
+		}
+	}
+
+	switch {
+	case change.Flow != nil:
+		err = l.StartFlowCtx(ctx, *change.Flow)
+	case change.Ct != nil:
+		err = l.SetCtAbxCtx(ctx, uint(*change.Ct), effect, duration)
+	case change.RGB != nil:
+		err = l.SetRGBCtx(ctx, *change.RGB, effect, duration)
+	case change.Hue != nil:
+		err = l.SetHSVCtx(ctx, *change.Hue, *change.Sat, effect, duration)
+	}
+	if err != nil {
+		return err
+	}
+
+	if change.Bright != nil {
+		return l.SetBrightCtx(ctx, *change.Bright, effect, duration)
+	}
+	return nil
+}
+
+// sceneOf returns the scene the change turns the light into, the brightness and the color missing from the change
+// are taken from the state. It returns nil if the change can't be made by a scene.
+func sceneOf(change Change, state LightProperties) Scene {
+	if change.Flow != nil {
+		return FlowScene{Flow: *change.Flow}
+	}
+
+	bright := state.Bright
+	if change.Bright != nil {
+		bright = *change.Bright
+	}
+	if bright == 0 {
+		return nil
+	}
+
+	switch {
+	case change.Ct != nil:
+		return CTScene{Ct: *change.Ct, Bright: bright}
+	case change.RGB != nil:
+		return ColorScene{RGB: *change.RGB, Bright: bright}
+	case change.Hue != nil:
+		return HSVScene{Hue: *change.Hue, Sat: *change.Sat, Bright: bright}
+	}
+
+	// only the brightness is changed, so the light keeps its color
+	if !state.Present.Has("color_mode") || state.Flowing {
+		return nil
+	}
+	switch state.Color_Mode {
+	case ColorModeCT:
+		if state.Ct == 0 {
+			return nil
+		}
+		return CTScene{Ct: state.Ct, Bright: bright}
+	case ColorModeRGB:
+		return ColorScene{RGB: state.RGB, Bright: bright}
+	case ColorModeHSV:
+		return HSVScene{Hue: state.Hue, Sat: state.Sat, Bright: bright}
+	}
+	return nil
+}
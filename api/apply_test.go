@@ -0,0 +1,39 @@
+package api
+
+import (
+	"testing"
+)
+
+func TestApplyNothing(t *testing.T) {
+	on := true
+	tests := []struct {
+		name      string
+		power     string
+		change    Change
+		wantPower string
+	}{
+		{"nothing", "off", Change{}, "off"},
+		{"just a transition", "off", Change{Transition: Transition{Effect: "smooth", Duration: 2000}}, "off"},
+		{"turned on", "off", Change{On: &on}, "on"},
+		{"on already", "on", Change{On: &on}, "on"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			bulb, light := newTestLight(t)
+			bulb.Change(map[string]string{"power": test.power})
+			err := light.GetProp()
+			if err != nil {
+				t.Fatalf("GetProp() failed: %v", err)
+			}
+
+			err = light.Apply(test.change)
+			if err != nil {
+				t.Fatalf("Apply() failed: %v", err)
+			}
+			if power := bulb.Prop("power"); power != test.wantPower {
+				t.Errorf("power of the bulb = %v, want %v", power, test.wantPower)
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package api
+
+import (
+	"github.com/dsorm/yeelight2mqtt/api/color"
+	"strings"
+)
+
+// color temperature ranges of the models which don't go down to 1700 K, keyed by the prefix of the model
+var ctRanges = map[string][2]uint16{
+	"ct_bulb":  {2700, 6500},
+	"ceiling":  {2700, 6500},
+	"ceila":    {2700, 6500},
+	"desklamp": {2700, 6500},
+	"lamp":     {2700, 6500},
+}
+
+// Supports reports whether the light knows the method (as listed in Support). Every method is assumed
+// to be supported if Support is empty, e.g. when the light is configured only by its address.
+func (l *Light) Supports(method string) bool {
+	l.describeMutex.Lock()
+	defer l.describeMutex.Unlock()
+
+	if len(l.Support) == 0 {
+		return true
+	}
+	for _, supported := range l.Support {
+		if supported == method {
+			return true
+		}
+	}
+	return false
+}
+
+// HasBackground reports whether the light has a background light (ambilight)
+func (l *Light) HasBackground() bool {
+	return l.Supports("bg_set_power")
+}
+
+// HasMoonlight reports whether the light has a moonlight (night light) mode, which only ceiling lights have.
+// It's assumed to have one if the model isn't known.
+func (l *Light) HasMoonlight() bool {
+	model := l.GetModel()
+	return model == "" || strings.HasPrefix(model, "ceiling") || strings.HasPrefix(model, "ceila")
+}
+
+// EmulatesCt reports whether the color temperature of the main light is emulated by the RGB color of the black
+// body at that temperature, which is the case for the RGB lights without set_ct_abx
+func (l *Light) EmulatesCt() bool {
+	return !l.Supports("set_ct_abx") && l.Supports("set_rgb")
+}
+
+// BgEmulatesCt is like EmulatesCt, but for the background light
+func (l *Light) BgEmulatesCt() bool {
+	return !l.Supports("bg_set_ct_abx") && l.Supports("bg_set_rgb")
+}
+
+// keepEmulatedCt carries the emulated color temperature over from the previous state to the refreshed one, as long
+// as the light shows the color of the black body at that temperature. The light itself only reports the rgb.
+func (l *Light) keepEmulatedCt(previous LightProperties, lp *LightProperties) {
+	if l.EmulatesCt() && previous.Color_Mode == ColorModeCT && previous.Ct > 0 && lp.Color_Mode == ColorModeRGB &&
+		lp.RGB == color.KelvinToRGB(float64(previous.Ct)).Int() {
+		lp.Ct, lp.Color_Mode = previous.Ct, ColorModeCT
+	}
+	if l.BgEmulatesCt() && previous.Bg_Color_Mode == ColorModeCT && previous.Bg_Ct > 0 && lp.Bg_Color_Mode == ColorModeRGB &&
+		lp.Bg_RGB == color.KelvinToRGB(float64(previous.Bg_Ct)).Int() {
+		lp.Bg_Ct, lp.Bg_Color_Mode = previous.Bg_Ct, ColorModeCT
+	}
+}
+
+// CtRange returns the lowest and the highest color temperature (in Kelvin) of the main light
+func (l *Light) CtRange() (uint16, uint16) {
+	model := l.GetModel()
+	for prefix, ctRange := range ctRanges {
+		if strings.HasPrefix(model, prefix) {
+			return ctRange[0], ctRange[1]
+		}
+	}
+	return 1700, 6500
+}
+
+// BgCtRange is like CtRange, but for the background light. The background lights are RGB ambilights, which have
+// the full range whatever the model is.
+func (l *Light) BgCtRange() (uint16, uint16) {
+	return 1700, 6500
+}
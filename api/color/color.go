@@ -0,0 +1,206 @@
+/*
+Package color converts colors between the representations used by the lights and their controllers: RGB (as in
+set_rgb), HSV (as in set_hsv), CIE 1931 xy and the color temperature in Kelvin or mireds (as in set_ct_abx).
+
+The RGB colors are sRGB. A color temperature is converted to the color of the black body at that temperature,
+which is what the white lights aim for, so it's only an approximation of what the light actually looks like.
+*/
+package color
+
+import "math"
+
+// RGB is an sRGB color, each channel ranges from 0 to 255
+type RGB struct {
+	R, G, B uint8
+}
+
+// HSV is a color as the hue (range 0 - 360), the saturation (range 0 - 100) and the value (range 0 - 100)
+type HSV struct {
+	H, S, V float64
+}
+
+// XY is the chromaticity of a color in the CIE 1931 color space, the brightness is left out
+type XY struct {
+	X, Y float64
+}
+
+// white point of sRGB (D65), the chromaticity of black
+var whiteXY = XY{X: 0.3127, Y: 0.3290}
+
+// RGBFromInt splits the rgb_value of the lights (RED*65536 + GREEN*256 + BLUE) into the channels
+func RGBFromInt(value uint32) RGB {
+	return RGB{R: uint8(value >> 16), G: uint8(value >> 8), B: uint8(value)}
+}
+
+// Int returns the rgb_value of the lights (RED*65536 + GREEN*256 + BLUE)
+func (c RGB) Int() uint32 {
+	return uint32(c.R)<<16 | uint32(c.G)<<8 | uint32(c.B)
+}
+
+func (c RGB) HSV() HSV {
+	r, g, b := float64(c.R)/255, float64(c.G)/255, float64(c.B)/255
+	max := math.Max(r, math.Max(g, b))
+	min := math.Min(r, math.Min(g, b))
+	delta := max - min
+
+	hsv := HSV{V: max * 100}
+	if max > 0 {
+		hsv.S = delta / max * 100
+	}
+	if delta == 0 {
+		return hsv
+	}
+
+	switch max {
+	case r:
+		hsv.H = 60 * math.Mod((g-b)/delta, 6)
+	case g:
+		hsv.H = 60 * ((b-r)/delta + 2)
+	default:
+		hsv.H = 60 * ((r-g)/delta + 4)
+	}
+	if hsv.H < 0 {
+		hsv.H += 360
+	}
+	return hsv
+}
+
+func (c HSV) RGB() RGB {
+	h := math.Mod(c.H, 360)
+	if h < 0 {
+		h += 360
+	}
+	s, v := clamp(c.S/100, 0, 1), clamp(c.V/100, 0, 1)
+
+	chroma := v * s
+	x := chroma * (1 - math.Abs(math.Mod(h/60, 2)-1))
+	m := v - chroma
+
+	var r, g, b float64
+	switch {
+	case h < 60:
+		r, g, b = chroma, x, 0
+	case h < 120:
+		r, g, b = x, chroma, 0
+	case h < 180:
+		r, g, b = 0, chroma, x
+	case h < 240:
+		r, g, b = 0, x, chroma
+	case h < 300:
+		r, g, b = x, 0, chroma
+	default:
+		r, g, b = chroma, 0, x
+	}
+	return RGB{R: channel(r + m), G: channel(g + m), B: channel(b + m)}
+}
+
+// XY returns the chromaticity of the color, black has the chromaticity of white
+func (c RGB) XY() XY {
+	r, g, b := linear(c.R), linear(c.G), linear(c.B)
+
+	x := 0.4124*r + 0.3576*g + 0.1805*b
+	y := 0.2126*r + 0.7152*g + 0.0722*b
+	z := 0.0193*r + 0.1192*g + 0.9505*b
+
+	sum := x + y + z
+	if sum == 0 {
+		return whiteXY
+	}
+	return XY{X: x / sum, Y: y / sum}
+}
+
+// RGB returns the brightest sRGB color of the chromaticity, the colors sRGB can't show are desaturated
+func (c XY) RGB() RGB {
+	if c.Y <= 0 {
+		return RGB{}
+	}
+	x, y, z := c.X/c.Y, 1.0, (1-c.X-c.Y)/c.Y
+
+	r := math.Max(0, 3.2406*x-1.5372*y-0.4986*z)
+	g := math.Max(0, -0.9689*x+1.8758*y+0.0415*z)
+	b := math.Max(0, 0.0557*x-0.2040*y+1.0570*z)
+
+	max := math.Max(r, math.Max(g, b))
+	if max == 0 {
+		return RGB{}
+	}
+	return RGB{R: channel(gamma(r / max)), G: channel(gamma(g / max)), B: channel(gamma(b / max))}
+}
+
+// Kelvin returns the correlated color temperature of the chromaticity (McCamy's approximation),
+// it's meaningful only for the colors close to white
+func (c XY) Kelvin() float64 {
+	n := (c.X - 0.3320) / (0.1858 - c.Y)
+	return 449*n*n*n + 3525*n*n + 6823.3*n + 5520.33
+}
+
+// KelvinToXY returns the chromaticity of the black body at the color temperature (Kim et al.'s approximation
+// of the Planckian locus), the color temperature is kept within 1667 - 25000 K
+func KelvinToXY(kelvin float64) XY {
+	t := clamp(kelvin, 1667, 25000)
+
+	var x float64
+	if t <= 4000 {
+		x = -0.2661239e9/(t*t*t) - 0.2343589e6/(t*t) + 0.8776956e3/t + 0.179910
+	} else {
+		x = -3.0258469e9/(t*t*t) + 2.1070379e6/(t*t) + 0.2226347e3/t + 0.240390
+	}
+
+	var y float64
+	switch {
+	case t <= 2222:
+		y = -1.1063814*x*x*x - 1.34811020*x*x + 2.18555832*x - 0.20219683
+	case t <= 4000:
+		y = -0.9549476*x*x*x - 1.37418593*x*x + 2.09137015*x - 0.16748867
+	default:
+		y = 3.0817580*x*x*x - 5.87338670*x*x + 3.75112997*x - 0.37001483
+	}
+	return XY{X: x, Y: y}
+}
+
+// KelvinToRGB returns the brightest sRGB color of the black body at the color temperature
+func KelvinToRGB(kelvin float64) RGB {
+	return KelvinToXY(kelvin).RGB()
+}
+
+// KelvinToMireds converts the color temperature to mireds (micro reciprocal degrees)
+func KelvinToMireds(kelvin float64) float64 {
+	if kelvin <= 0 {
+		return 0
+	}
+	return 1000000 / kelvin
+}
+
+// MiredsToKelvin converts the color temperature from mireds (micro reciprocal degrees)
+func MiredsToKelvin(mireds float64) float64 {
+	if mireds <= 0 {
+		return 0
+	}
+	return 1000000 / mireds
+}
+
+// linear undoes the gamma of an sRGB channel
+func linear(c uint8) float64 {
+	v := float64(c) / 255
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+// gamma applies the gamma of sRGB to a linear channel (range 0 - 1)
+func gamma(v float64) float64 {
+	if v <= 0.0031308 {
+		return 12.92 * v
+	}
+	return 1.055*math.Pow(v, 1/2.4) - 0.055
+}
+
+// channel converts the channel from the range 0 - 1 to 0 - 255
+func channel(v float64) uint8 {
+	return uint8(math.Round(clamp(v, 0, 1) * 255))
+}
+
+func clamp(v, min, max float64) float64 {
+	return math.Max(min, math.Min(max, v))
+}
@@ -0,0 +1,162 @@
+package color
+
+import (
+	"math"
+	"testing"
+)
+
+func TestRGBToHSV(t *testing.T) {
+	tests := []struct {
+		rgb  RGB
+		want HSV
+	}{
+		{RGB{255, 0, 0}, HSV{0, 100, 100}},
+		{RGB{0, 255, 0}, HSV{120, 100, 100}},
+		{RGB{0, 0, 255}, HSV{240, 100, 100}},
+		{RGB{255, 0, 255}, HSV{300, 100, 100}},
+		{RGB{255, 255, 255}, HSV{0, 0, 100}},
+		{RGB{0, 0, 0}, HSV{0, 0, 0}},
+		{RGB{128, 128, 128}, HSV{0, 0, 100 * 128.0 / 255}},
+	}
+
+	for _, test := range tests {
+		got := test.rgb.HSV()
+		if !near(got.H, test.want.H, 0.01) || !near(got.S, test.want.S, 0.01) || !near(got.V, test.want.V, 0.01) {
+			t.Errorf("%+v.HSV() = %+v, want %+v", test.rgb, got, test.want)
+		}
+	}
+}
+
+func TestHSVToRGB(t *testing.T) {
+	tests := []struct {
+		hsv  HSV
+		want RGB
+	}{
+		{HSV{0, 100, 100}, RGB{255, 0, 0}},
+		{HSV{120, 100, 100}, RGB{0, 255, 0}},
+		{HSV{240, 100, 100}, RGB{0, 0, 255}},
+		{HSV{0, 0, 100}, RGB{255, 255, 255}},
+		// the hue wraps around, the saturation and the value are clamped
+		{HSV{360, 100, 100}, RGB{255, 0, 0}},
+		{HSV{-120, 100, 100}, RGB{0, 0, 255}},
+		{HSV{120, 150, 100}, RGB{0, 255, 0}},
+		{HSV{120, 100, 200}, RGB{0, 255, 0}},
+		{HSV{120, -10, 100}, RGB{255, 255, 255}},
+		{HSV{120, 100, -10}, RGB{0, 0, 0}},
+	}
+
+	for _, test := range tests {
+		if got := test.hsv.RGB(); got != test.want {
+			t.Errorf("%+v.RGB() = %+v, want %+v", test.hsv, got, test.want)
+		}
+	}
+}
+
+func TestRGBRoundTrip(t *testing.T) {
+	for _, rgb := range []RGB{{255, 0, 0}, {255, 128, 0}, {12, 34, 56}, {200, 200, 200}, {1, 2, 3}, {0, 0, 0}, {255, 255, 255}} {
+		if got := rgb.HSV().RGB(); got != rgb {
+			t.Errorf("%+v to HSV and back = %+v", rgb, got)
+		}
+		if got := RGBFromInt(rgb.Int()); got != rgb {
+			t.Errorf("RGBFromInt(%v) = %+v, want %+v", rgb.Int(), got, rgb)
+		}
+	}
+
+	if got := RGBFromInt(0xFF8000); got != (RGB{255, 128, 0}) {
+		t.Errorf("RGBFromInt(0xFF8000) = %+v, want {255 128 0}", got)
+	}
+}
+
+func TestXY(t *testing.T) {
+	tests := []struct {
+		rgb  RGB
+		want XY
+	}{
+		// the primaries and the white point of sRGB
+		{RGB{255, 0, 0}, XY{0.64, 0.33}},
+		{RGB{0, 255, 0}, XY{0.30, 0.60}},
+		{RGB{0, 0, 255}, XY{0.15, 0.06}},
+		{RGB{255, 255, 255}, whiteXY},
+		{RGB{0, 0, 0}, whiteXY},
+	}
+
+	for _, test := range tests {
+		got := test.rgb.XY()
+		if !near(got.X, test.want.X, 0.001) || !near(got.Y, test.want.Y, 0.001) {
+			t.Errorf("%+v.XY() = %+v, want %+v", test.rgb, got, test.want)
+		}
+
+		// the chromaticity is converted back to the brightest color of it
+		if test.rgb != (RGB{}) {
+			if back := got.RGB(); back != test.rgb {
+				t.Errorf("%+v.RGB() = %+v, want %+v", got, back, test.rgb)
+			}
+		}
+	}
+
+	if got := (XY{X: 0.3, Y: 0}).RGB(); got != (RGB{}) {
+		t.Errorf("XY with y 0 .RGB() = %+v, want black", got)
+	}
+}
+
+func TestKelvin(t *testing.T) {
+	// D65 is a little off the Planckian locus
+	if xy := KelvinToXY(6500); !near(xy.X, whiteXY.X, 0.002) || !near(xy.Y, whiteXY.Y, 0.006) {
+		t.Errorf("KelvinToXY(6500) = %+v, want about D65 %+v", xy, whiteXY)
+	}
+	if kelvin := whiteXY.Kelvin(); !near(kelvin, 6504, 10) {
+		t.Errorf("Kelvin() of D65 = %v, want about 6504", kelvin)
+	}
+
+	// the color temperature survives the conversion to xy and back
+	for _, kelvin := range []float64{1700, 2700, 4000, 5000, 6500} {
+		if got := KelvinToXY(kelvin).Kelvin(); !near(got, kelvin, kelvin*0.02) {
+			t.Errorf("KelvinToXY(%v).Kelvin() = %v", kelvin, got)
+		}
+	}
+
+	// the color temperature is kept within the range of the approximation
+	if got, want := KelvinToXY(1000), KelvinToXY(1667); got != want {
+		t.Errorf("KelvinToXY(1000) = %+v, want %+v", got, want)
+	}
+	if got, want := KelvinToXY(40000), KelvinToXY(25000); got != want {
+		t.Errorf("KelvinToXY(40000) = %+v, want %+v", got, want)
+	}
+
+	// warm white is red and cold white is blue
+	if warm := KelvinToRGB(2700); warm.R != 255 || warm.B >= warm.G {
+		t.Errorf("KelvinToRGB(2700) = %+v, want a warm white", warm)
+	}
+	if cold := KelvinToRGB(10000); cold.B != 255 || cold.R >= cold.G {
+		t.Errorf("KelvinToRGB(10000) = %+v, want a cold white", cold)
+	}
+}
+
+func TestMireds(t *testing.T) {
+	tests := []struct {
+		kelvin float64
+		mireds float64
+	}{
+		{5000, 200},
+		{6500, 1000000.0 / 6500},
+		{2000, 500},
+		{0, 0},
+	}
+
+	for _, test := range tests {
+		if got := KelvinToMireds(test.kelvin); !near(got, test.mireds, 1e-9) {
+			t.Errorf("KelvinToMireds(%v) = %v, want %v", test.kelvin, got, test.mireds)
+		}
+		if got := MiredsToKelvin(test.mireds); !near(got, test.kelvin, 1e-9) {
+			t.Errorf("MiredsToKelvin(%v) = %v, want %v", test.mireds, got, test.kelvin)
+		}
+	}
+
+	if got := MiredsToKelvin(-10); got != 0 {
+		t.Errorf("MiredsToKelvin(-10) = %v, want 0", got)
+	}
+}
+
+func near(got, want, tolerance float64) bool {
+	return math.Abs(got-want) <= tolerance
+}
@@ -1,128 +1,129 @@
 package api
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
-	"github.com/dsorm/yeelight2mqtt/console"
-	"log"
-	"reflect"
+	"github.com/dsorm/yeelight2mqtt/api/color"
 	"strconv"
 	"strings"
 )
 
-func (l *Light) sendVerify(funcName string, command string, params ...interface{}) error {
-	formattedCommand := fmt.Sprintf(command, params...)
-	response, err := l.SendCommand(formattedCommand, 10)
+func (l *Light) sendVerify(ctx context.Context, funcName string, method string, params ...interface{}) error {
+	return l.sendVerifyTries(ctx, funcName, 10, method, params...)
+}
+
+// sendVerifyOnce is like sendVerify, but the command isn't sent again if it fails. Used for the relative commands
+// (e.g. adjust_bright), which would be applied twice if the light executed the first one, but the response got lost.
+func (l *Light) sendVerifyOnce(ctx context.Context, funcName string, method string, params ...interface{}) error {
+	return l.sendVerifyTries(ctx, funcName, 1, method, params...)
+}
 
+func (l *Light) sendVerifyTries(ctx context.Context, funcName string, maxTries int, method string, params ...interface{}) error {
+	result, err := l.SendCommandCtx(ctx, method, params, maxTries)
 	if err != nil {
-		return err
+		return fmt.Errorf("%v() failed: %w", funcName, err)
 	}
 
-	if !strings.Contains(string(response), "ok") {
-		return fmt.Errorf("%v() failed:\n\tresponse from light: %v", funcName, string(response))
+	if len(result) != 1 || result[0] != "ok" {
+		return fmt.Errorf("%v() failed:\n\tresponse from light: %v", funcName, result)
 	}
 
 	return nil
 }
 
+// GetProp refreshes the state of the light, see GetState. Properties the light doesn't have are left out of
+// LightProperties.Present, and the values that can't be parsed are reported by an error matching ErrInvalidProp,
+// the rest of the state is refreshed anyway.
 func (l *Light) GetProp() error {
-	command := "{\"id\":0,\"method\":\"get_prop\",\"params\":[\"power\", \"bright\", \"ct\", \"rgb\", \"hue\", \"sat\", \"color_mode\", \"flowing\", \"delayoff\", \"flow_params\", \"music_on\", \"name\", \"bg_power\", \"bg_flowing\", \"bg_flow_params\", \"bg_ct\", \"bg_lmode\", \"bg_bright\", \"bg_rgb\", \"bg_hue\", \"bg_sat\", \"nl_br\", \"active_mode\"]}"
-	resp, err := l.SendCommand(command, 3)
+	return l.GetPropCtx(context.Background())
+}
 
-	if err != nil {
-		return err
+// GetPropCtx is like GetProp, but it gives up when ctx is done
+func (l *Light) GetPropCtx(ctx context.Context) error {
+	props := make([]interface{}, len(propNames))
+	for k, name := range propNames {
+		props[k] = name
 	}
 
-	// var props lightProperties
-	d := json.NewDecoder(bytes.NewReader(resp))
-
-	var m map[string]interface{}
-	err = d.Decode(&m)
+	result, err := l.SendCommandWithPriorityCtx(ctx, PriorityLow, "get_prop", props, 3)
 	if err != nil {
-		return err
+		return fmt.Errorf("GetProp() failed: %w", err)
 	}
 
-	// make sure m["result"] is not nill
-	_, keyExists := m["result"]
-	if !keyExists {
-		return fmt.Errorf("GetProp() failed: output from light doesn't contain result")
-	}
+	// the values are in the same order as the names, the ones missing at the end are treated as empty
+	lp := LightProperties{}
+	var invalid []string
+	for k, name := range propNames {
+		value := ""
+		if k < len(result) {
+			value = propString(result[k])
+		}
 
-	// make sure m["result"] is the right type
-	if reflect.TypeOf(m["result"]).Kind() != reflect.Slice {
-		return fmt.Errorf("GetProp() failed: %v", m["result"])
+		_, err := lp.set(name, value)
+		if err != nil {
+			invalid = append(invalid, err.Error())
+		}
 	}
-	result := m["result"].([]interface{})
-	if len(result) < 21 {
-		return fmt.Errorf("GetProp() failed: result too small (expected at least 21, got %v), result is %v\n", len(result), result)
+
+	l.stateMutex.Lock()
+	l.keepEmulatedCt(l.latestState, &lp)
+	l.latestState = lp
+	l.stateMutex.Unlock()
+
+	if len(invalid) > 0 {
+		return fmt.Errorf("GetProp() failed: %w: %v", ErrInvalidProp, strings.Join(invalid, ", "))
 	}
+	return nil
+}
 
-	atouint8 := func(i interface{}) uint8 {
-		s := i.(string)
-		if i.(string) == "" {
-			return 0
-		}
+// GetProps reads the properties (as used by get_prop) of the light, e.g. GetProps("power", "save_state").
+// The values are returned as the light has sent them, an empty string means the light doesn't have the property.
+// The properties tracked in LightProperties are refreshed in the state as well.
+func (l *Light) GetProps(names ...string) (map[string]string, error) {
+	return l.GetPropsCtx(context.Background(), names...)
+}
 
-		num, err := strconv.Atoi(s)
-		if err != nil {
-			log.Fatalf("atoi: %v", err)
-		}
-		return uint8(num)
+// GetPropsCtx is like GetProps, but it gives up when ctx is done
+func (l *Light) GetPropsCtx(ctx context.Context, names ...string) (map[string]string, error) {
+	if len(names) == 0 {
+		return nil, fmt.Errorf("GetProps() failed: no properties given")
 	}
 
-	atouint16 := func(i interface{}) uint16 {
-		s := i.(string)
-		if i.(string) == "" {
-			return 0
-		}
-		num, err := strconv.Atoi(s)
-		if err != nil {
-			console.Logf("atoi: %v\n", err)
-		}
-		return uint16(num)
+	props := make([]interface{}, len(names))
+	for k, name := range names {
+		props[k] = name
+	}
+
+	result, err := l.SendCommandCtx(ctx, "get_prop", props, 3)
+	if err != nil {
+		return nil, fmt.Errorf("GetProps() failed: %w", err)
 	}
 
-	atouint32 := func(i interface{}) uint32 {
-		s := i.(string)
-		if i.(string) == "" {
-			return 0
+	values := make(map[string]string, len(names))
+	var invalid []string
+
+	l.stateMutex.Lock()
+	previous := l.latestState
+	for k, name := range names {
+		value := ""
+		if k < len(result) {
+			value = propString(result[k])
 		}
+		values[name] = value
 
-		num, err := strconv.Atoi(s)
+		_, err := l.latestState.set(name, value)
 		if err != nil {
-			console.Logf("atoi: %v\n", err)
+			invalid = append(invalid, err.Error())
 		}
-		return uint32(num)
-	}
-	lp := LightProperties{
-		On:             result[0].(string) == "on",
-		Bright:         atouint8(result[1]),
-		Ct:             atouint16(result[2]),
-		RGB:            atouint32(result[3]),
-		Hue:            atouint16(result[4]),
-		Sat:            atouint8(result[5]),
-		Color_Mode:     ColorMode(atouint8(result[6])),
-		Flowing:        result[7].(string) == "1",
-		Delayoff:       atouint8(result[8]),
-		Flow_Params:    result[9].(string),
-		Music_On:       result[10].(string) == "1",
-		Name:           result[11].(string),
-		Bg_On:          result[12].(string) == "on",
-		Bg_Flowing:     result[13].(string) == "1",
-		Bg_Flow_Params: result[14].(string),
-		Bg_Ct:          atouint16(result[15]),
-		Bg_Color_Mode:  ColorMode(atouint8(result[16])),
-		Bg_Bright:      atouint8(result[17]),
-		Bg_RGB:         atouint32(result[18]),
-		Bg_Hue:         atouint16(result[19]),
-		Bg_Sat:         atouint8(result[20]),
-		Nl_Br:          atouint8(result[21]),
-		Moonlight_On:   result[22].(string) == "1",
 	}
+	l.keepEmulatedCt(previous, &l.latestState)
+	l.stateMutex.Unlock()
 
-	l.latestState = lp
-	return nil
+	if len(invalid) > 0 {
+		return values, fmt.Errorf("GetProps() failed: %w: %v", ErrInvalidProp, strings.Join(invalid, ", "))
+	}
+	return values, nil
 }
 
 /*
@@ -141,31 +142,39 @@ range is 1700 ~ 6500 (k).
 
 	The minimum support duration is 30 milliseconds.
 
-From Yeelight's Inter-operation Specification
+# From Yeelight's Inter-operation Specification
+
+The RGB lights without set_ct_abx are sent the color of the black body at the color temperature instead,
+see EmulatesCt.
 */
 func (l *Light) SetCtAbx(ct_value uint, effect string, duration string) error {
-	if ct_value < 1700 || ct_value > 6500 {
-		return fmt.Errorf("SetCtAbx() failed: ct_value out of range")
-	}
-	if effect != "sudden" && effect != "smooth" {
-		return fmt.Errorf("SetCtAbx() failed: effect must be 'sudden' or 'smooth'")
-	}
+	return l.SetCtAbxCtx(context.Background(), ct_value, effect, duration)
+}
 
-	durationConv, err := strconv.Atoi(duration)
-	if err != nil {
-		return fmt.Errorf("SetCtAbx() failed: duration must be an integer")
+// SetCtAbxCtx is like SetCtAbx, but it gives up when ctx is done
+func (l *Light) SetCtAbxCtx(ctx context.Context, ct_value uint, effect string, duration string) error {
+	minCt, maxCt := l.CtRange()
+	if ct_value < uint(minCt) || ct_value > uint(maxCt) {
+		return fmt.Errorf("SetCtAbx() failed: ct_value out of range (%v - %v)", minCt, maxCt)
 	}
-	if durationConv < 30 {
-		return fmt.Errorf("SetCtAbx() failed: duration must be at least 30 ms")
+	err := validateTransition(effect, duration)
+	if err != nil {
+		return fmt.Errorf("SetCtAbx() failed: %v", err)
 	}
 
-	err = l.sendVerify("SetCtAbx", "{\"id\":0,\"method\":\"set_ct_abx\",\"params\":[%v, \"%v\", %v]}", ct_value, effect, duration)
+	// the requested color temperature is kept even if it's emulated, the rgb only approximates it
+	if l.EmulatesCt() {
+		err = l.SetRGBCtx(ctx, color.KelvinToRGB(float64(ct_value)).Int(), effect, duration)
+	} else {
+		err = l.sendVerify(ctx, "SetCtAbx", "set_ct_abx", ct_value, effect, json.Number(duration))
+	}
 	if err != nil {
 		return err
 	}
 
 	l.stateMutex.Lock()
 	l.latestState.Ct = uint16(ct_value)
+	l.latestState.Color_Mode = ColorModeCT
 	l.stateMutex.Unlock()
 	return nil
 }
@@ -192,17 +201,26 @@ func (l *Light) SetCtAbx(ct_value uint, effect string, duration string) error {
 From Yeelight's Inter-operation Specification
 */
 func (l *Light) SetRGB(rgb_value uint32, effect string, duration string) error {
+	return l.SetRGBCtx(context.Background(), rgb_value, effect, duration)
+}
+
+// SetRGBCtx is like SetRGB, but it gives up when ctx is done
+func (l *Light) SetRGBCtx(ctx context.Context, rgb_value uint32, effect string, duration string) error {
+	if err := validateTransition(effect, duration); err != nil {
+		return fmt.Errorf("SetRGB() failed: %v", err)
+	}
 	if rgb_value > 16777215 {
 		return fmt.Errorf("SetRGB() failed: rgb_value out of range")
 	}
 
-	err := l.sendVerify("SetRGB", "{\"id\":0,\"method\":\"set_rgb\",\"params\":[%v, \"%v\", %v]}", rgb_value, effect, duration)
+	err := l.sendVerify(ctx, "SetRGB", "set_rgb", rgb_value, effect, json.Number(duration))
 	if err != nil {
 		return err
 	}
 
 	l.stateMutex.Lock()
 	l.latestState.RGB = rgb_value
+	l.latestState.Color_Mode = ColorModeRGB
 	l.stateMutex.Unlock()
 	return nil
 }
@@ -231,6 +249,14 @@ func (l *Light) SetRGB(rgb_value uint32, effect string, duration string) error {
 From Yeelight's Inter-operation Specification
 */
 func (l *Light) SetHSV(hue uint16, sat uint8, effect string, duration string) error {
+	return l.SetHSVCtx(context.Background(), hue, sat, effect, duration)
+}
+
+// SetHSVCtx is like SetHSV, but it gives up when ctx is done
+func (l *Light) SetHSVCtx(ctx context.Context, hue uint16, sat uint8, effect string, duration string) error {
+	if err := validateTransition(effect, duration); err != nil {
+		return fmt.Errorf("SetHSV() failed: %v", err)
+	}
 	if hue > 359 {
 		return fmt.Errorf("SetHSV() failed: hue out of range")
 	}
@@ -238,13 +264,15 @@ func (l *Light) SetHSV(hue uint16, sat uint8, effect string, duration string) er
 		return fmt.Errorf("SetHSV() failed: sat out of range")
 	}
 
-	err := l.sendVerify("SetHSV", "{\"id\":0,\"method\":\"set_hsv\",\"params\":[%v, %v, \"%v\", %v]}", hue, sat, effect, duration)
+	err := l.sendVerify(ctx, "SetHSV", "set_hsv", hue, sat, effect, json.Number(duration))
 	if err != nil {
 		return err
 	}
 
 	l.stateMutex.Lock()
 	l.latestState.Hue = hue
+	l.latestState.Sat = sat
+	l.latestState.Color_Mode = ColorModeHSV
 	l.stateMutex.Unlock()
 	return nil
 }
@@ -271,11 +299,19 @@ func (l *Light) SetHSV(hue uint16, sat uint8, effect string, duration string) er
 From Yeelight's Inter-operation Specification
 */
 func (l *Light) SetBright(brightness uint8, effect string, duration string) error {
+	return l.SetBrightCtx(context.Background(), brightness, effect, duration)
+}
+
+// SetBrightCtx is like SetBright, but it gives up when ctx is done
+func (l *Light) SetBrightCtx(ctx context.Context, brightness uint8, effect string, duration string) error {
+	if err := validateTransition(effect, duration); err != nil {
+		return fmt.Errorf("SetBright() failed: %v", err)
+	}
 	if brightness < 1 || brightness > 100 {
 		return fmt.Errorf("SetBright() failed: brightness out of range")
 	}
 
-	err := l.sendVerify("SetBright", "{\"id\":0,\"method\":\"set_bright\",\"params\":[%v, \"%v\", %v]}", brightness, effect, duration)
+	err := l.sendVerify(ctx, "SetBright", "set_bright", brightness, effect, json.Number(duration))
 	if err != nil {
 		return err
 	}
@@ -312,24 +348,39 @@ func (l *Light) SetBright(brightness uint8, effect string, duration string) erro
 		From Yeelight's Inter-operation Specification
 */
 func (l *Light) SetPower(power string, effect string, duration string, mode string) error {
+	return l.SetPowerCtx(context.Background(), power, effect, duration, mode)
+}
+
+// SetPowerCtx is like SetPower, but it gives up when ctx is done
+func (l *Light) SetPowerCtx(ctx context.Context, power string, effect string, duration string, mode string) error {
+	if err := validateTransition(effect, duration); err != nil {
+		return fmt.Errorf("SetPower() failed: %v", err)
+	}
 	if len(mode) == 0 {
 		mode = "0"
 	}
 
-	err := l.sendVerify("SetPower", "{\"id\":0,\"method\":\"set_power\",\"params\":[\"%v\", \"%v\", %v, %v]}", power, effect, duration, mode)
+	err := l.sendVerify(ctx, "SetPower", "set_power", power, effect, json.Number(duration), json.Number(mode))
 	if err != nil {
 		return err
 	}
 
 	l.stateMutex.Lock()
 	l.latestState.On = power == "on"
-	l.latestState.Color_Mode, _ = ColorModeFromString(mode)
+	if colorMode, ok := colorModeFromPowerMode(mode); ok {
+		l.latestState.Color_Mode = colorMode
+	}
 	l.stateMutex.Unlock()
 	return nil
 }
 
 func (l *Light) Toggle() error {
-	err := l.sendVerify("Toggle", "{\"id\":0,\"method\":\"toggle\",\"params\":[]}")
+	return l.ToggleCtx(context.Background())
+}
+
+// ToggleCtx is like Toggle, but it gives up when ctx is done
+func (l *Light) ToggleCtx(ctx context.Context) error {
+	err := l.sendVerify(ctx, "Toggle", "toggle")
 	if err != nil {
 		return err
 	}
@@ -340,8 +391,38 @@ func (l *Light) Toggle() error {
 	return nil
 }
 
+/*
+This method is used to save current state of smart LED in persistent memory. So if user powers off and then powers on the
+smart LED again (hard power reset), the smart LED will show last saved state.
+
+From Yeelight's Inter-operation Specification
+*/
 func (l *Light) SetDefault() error {
-	panic("not implemented")
+	return l.SetDefaultCtx(context.Background())
+}
+
+// SetDefaultCtx is like SetDefault, but it gives up when ctx is done
+func (l *Light) SetDefaultCtx(ctx context.Context) error {
+	return l.sendVerify(ctx, "SetDefault", "set_default")
+}
+
+// DevToggle toggles the main light and the background light at the same time
+func (l *Light) DevToggle() error {
+	return l.DevToggleCtx(context.Background())
+}
+
+// DevToggleCtx is like DevToggle, but it gives up when ctx is done
+func (l *Light) DevToggleCtx(ctx context.Context) error {
+	err := l.sendVerify(ctx, "DevToggle", "dev_toggle")
+	if err != nil {
+		return err
+	}
+
+	l.stateMutex.Lock()
+	l.latestState.On = !l.latestState.On
+	l.latestState.Bg_On = !l.latestState.Bg_On
+	l.stateMutex.Unlock()
+	return nil
 }
 
 /*
@@ -360,30 +441,57 @@ func (l *Light) SetDefault() error {
 From Yeelight's Inter-operation Specification
 */
 func (l *Light) StartCf(count uint64, action uint8, flow_expression string) error {
-	if action > 2 {
-		return fmt.Errorf("StartCf() failed: action out of range")
+	return l.StartCfCtx(context.Background(), count, action, flow_expression)
+}
+
+// StartCfCtx is like StartCf, but it gives up when ctx is done
+func (l *Light) StartCfCtx(ctx context.Context, count uint64, action uint8, flow_expression string) error {
+	steps, err := ParseFlowExpression(flow_expression)
+	if err != nil {
+		return fmt.Errorf("StartCf() failed: %v", err)
+	}
+
+	return l.StartFlowCtx(ctx, Flow{Count: count, Action: FlowAction(action), Steps: steps})
+}
+
+// StartFlow starts the color flow, it's StartCf with a typed flow
+func (l *Light) StartFlow(flow Flow) error {
+	return l.StartFlowCtx(context.Background(), flow)
+}
+
+// StartFlowCtx is like StartFlow, but it gives up when ctx is done
+func (l *Light) StartFlowCtx(ctx context.Context, flow Flow) error {
+	err := flow.Validate()
+	if err != nil {
+		return fmt.Errorf("StartCf() failed: %v", err)
 	}
 
-	err := l.sendVerify("StartCf", "{\"id\":0,\"method\":\"start_cf\",\"params\":[%v, %v, \"%v\"]}", count, action, flow_expression)
+	err = l.sendVerify(ctx, "StartCf", "start_cf", flow.Count, uint8(flow.Action), flow.Expression())
 	if err != nil {
 		return err
 	}
 
 	l.stateMutex.Lock()
 	l.latestState.Flowing = true
-	l.latestState.Flow_Params = flow_expression
+	l.latestState.Flow_Params = flow
+	l.stateMutex.Unlock()
 	return nil
 }
 
+// StopCf stops a running color flow, the flow params are kept (the light reports them until the next flow starts)
 func (l *Light) StopCf() error {
-	err := l.sendVerify("StopCf", "{\"id\":0,\"method\":\"stop_cf\",\"params\":[]}")
+	return l.StopCfCtx(context.Background())
+}
+
+// StopCfCtx is like StopCf, but it gives up when ctx is done
+func (l *Light) StopCfCtx(ctx context.Context) error {
+	err := l.sendVerify(ctx, "StopCf", "stop_cf")
 	if err != nil {
-		return nil
+		return err
 	}
 
 	l.stateMutex.Lock()
 	l.latestState.Flowing = false
-	l.latestState.Flow_Params = ""
 	l.stateMutex.Unlock()
 	return nil
 }
@@ -400,10 +508,43 @@ func (l *Light) StopCf() error {
 
 "val1", "val2", "val3" are class specific.
 
-From Yeelight's Inter-operation Specification
+# From Yeelight's Inter-operation Specification
+
+The class and its values are given by the type of scene, e.g. ColorScene. The RGB lights without set_ct_abx are
+sent a ColorScene of the color of the black body instead of a CTScene, see EmulatesCt.
 */
-func (l *Light) SetScene(scene string, val1 string, val2 string, val3 string) error {
-	panic("not implemented")
+func (l *Light) SetScene(scene Scene) error {
+	return l.SetSceneCtx(context.Background(), scene)
+}
+
+// SetSceneCtx is like SetScene, but it gives up when ctx is done
+func (l *Light) SetSceneCtx(ctx context.Context, scene Scene) error {
+	params, err := scene.params()
+	if err != nil {
+		return fmt.Errorf("SetScene() failed: %v", err)
+	}
+
+	sent := scene
+	if ct, ok := scene.(CTScene); ok {
+		minCt, maxCt := l.CtRange()
+		if ct.Ct < minCt || ct.Ct > maxCt {
+			return fmt.Errorf("SetScene() failed: ct_value out of range (%v - %v)", minCt, maxCt)
+		}
+		if l.EmulatesCt() {
+			sent = ColorScene{RGB: color.KelvinToRGB(float64(ct.Ct)).Int(), Bright: ct.Bright}
+			params, _ = sent.params()
+		}
+	}
+
+	err = l.sendVerify(ctx, "SetScene", "set_scene", append([]interface{}{sent.Class()}, params...)...)
+	if err != nil {
+		return err
+	}
+
+	l.stateMutex.Lock()
+	scene.apply(&l.latestState, false)
+	l.stateMutex.Unlock()
+	return nil
 }
 
 /*
@@ -415,7 +556,25 @@ func (l *Light) SetScene(scene string, val1 string, val2 string, val3 string) er
 "type" was omited since type is a reserved keyword in Go, and it doesn't even serve any purpose, because it can only be set to 0
 */
 func (l *Light) CronAdd(value string) error {
-	panic("not implemented")
+	return l.CronAddCtx(context.Background(), value)
+}
+
+// CronAddCtx is like CronAdd, but it gives up when ctx is done
+func (l *Light) CronAddCtx(ctx context.Context, value string) error {
+	minutes, err := strconv.Atoi(value)
+	if err != nil || minutes < 1 || minutes > 60 {
+		return fmt.Errorf("CronAdd() failed: value must be between 1 and 60 minutes")
+	}
+
+	err = l.sendVerify(ctx, "CronAdd", "cron_add", 0, minutes)
+	if err != nil {
+		return err
+	}
+
+	l.stateMutex.Lock()
+	l.latestState.Delayoff = uint8(minutes)
+	l.stateMutex.Unlock()
+	return nil
 }
 
 /*
@@ -424,9 +583,42 @@ func (l *Light) CronAdd(value string) error {
 # From Yeelight's Inter-operation Specification
 
 "type" was omited since type is a reserved keyword in Go, and it doesn't even serve any purpose, because it can only be set to 0
+
+It returns the minutes left until the light turns off, 0 means there's no timer.
 */
-func (l *Light) CronGet(type2 string) error {
-	panic("not implemented")
+func (l *Light) CronGet(type2 string) (uint8, error) {
+	return l.CronGetCtx(context.Background(), type2)
+}
+
+// CronGetCtx is like CronGet, but it gives up when ctx is done
+func (l *Light) CronGetCtx(ctx context.Context, type2 string) (uint8, error) {
+	if len(type2) == 0 {
+		type2 = "0"
+	}
+
+	result, err := l.SendCommandCtx(ctx, "cron_get", []interface{}{json.Number(type2)}, 3)
+	if err != nil {
+		return 0, err
+	}
+
+	// e.g. [{"type": 0, "delay": 15, "mix": 0}], or [] if there's no timer
+	var minutes uint8
+	if len(result) > 0 {
+		job, ok := result[0].(map[string]interface{})
+		if !ok {
+			return 0, fmt.Errorf("CronGet() failed: unexpected result %v", result)
+		}
+		delay, ok := job["delay"].(float64)
+		if !ok {
+			return 0, fmt.Errorf("CronGet() failed: unexpected result %v", result)
+		}
+		minutes = uint8(delay)
+	}
+
+	l.stateMutex.Lock()
+	l.latestState.Delayoff = minutes
+	l.stateMutex.Unlock()
+	return minutes, nil
 }
 
 /*
@@ -437,7 +629,24 @@ func (l *Light) CronGet(type2 string) error {
 "type" was omited since type is a reserved keyword in Go, and it doesn't even serve any purpose, because it can only be set to 0
 */
 func (l *Light) CronDel(type2 string) error {
-	panic("not implemented")
+	return l.CronDelCtx(context.Background(), type2)
+}
+
+// CronDelCtx is like CronDel, but it gives up when ctx is done
+func (l *Light) CronDelCtx(ctx context.Context, type2 string) error {
+	if len(type2) == 0 {
+		type2 = "0"
+	}
+
+	err := l.sendVerify(ctx, "CronDel", "cron_del", json.Number(type2))
+	if err != nil {
+		return err
+	}
+
+	l.stateMutex.Lock()
+	l.latestState.Delayoff = 0
+	l.stateMutex.Unlock()
+	return nil
 }
 
 /*
@@ -455,8 +664,97 @@ func (l *Light) CronDel(type2 string) error {
 
 		From Yeelight's Inter-operation Specification
 */
-func (l *Light) SetAdjust(action string, prop string) {
-	panic("not implemented")
+func (l *Light) SetAdjust(action string, prop string) error {
+	return l.SetAdjustCtx(context.Background(), action, prop)
+}
+
+// SetAdjustCtx is like SetAdjust, but it gives up when ctx is done
+func (l *Light) SetAdjustCtx(ctx context.Context, action string, prop string) error {
+	switch {
+	case action != "increase" && action != "decrease" && action != "circle":
+		return fmt.Errorf("SetAdjust() failed: invalid action '%v'", action)
+	case prop != "bright" && prop != "ct" && prop != "color":
+		return fmt.Errorf("SetAdjust() failed: invalid prop '%v'", prop)
+	case prop == "color" && action != "circle":
+		return fmt.Errorf("SetAdjust() failed: color can only be adjusted using circle")
+	}
+
+	return l.sendVerifyOnce(ctx, "SetAdjust", "set_adjust", action, prop)
+}
+
+/*
+"percentage" the percentage to be adjusted. The range is: -100 ~ 100
+
+"duration" Refer to "set_ct_abx" method.
+
+# From Yeelight's Inter-operation Specification
+
+The light notifies about the resulting brightness, it's not known before that.
+*/
+func (l *Light) AdjustBright(percentage int8, duration string) error {
+	return l.AdjustBrightCtx(context.Background(), percentage, duration)
+}
+
+// AdjustBrightCtx is like AdjustBright, but it gives up when ctx is done
+func (l *Light) AdjustBrightCtx(ctx context.Context, percentage int8, duration string) error {
+	if err := validateTransition("smooth", duration); err != nil {
+		return fmt.Errorf("AdjustBright() failed: %v", err)
+	}
+	if percentage < -100 || percentage > 100 {
+		return fmt.Errorf("AdjustBright() failed: percentage out of range")
+	}
+
+	return l.sendVerifyOnce(ctx, "AdjustBright", "adjust_bright", percentage, json.Number(duration))
+}
+
+/*
+"percentage" the percentage to be adjusted. The range is: -100 ~ 100
+
+"duration" Refer to "set_ct_abx" method.
+
+# From Yeelight's Inter-operation Specification
+
+The light notifies about the resulting color temperature, it's not known before that.
+*/
+func (l *Light) AdjustCt(percentage int8, duration string) error {
+	return l.AdjustCtCtx(context.Background(), percentage, duration)
+}
+
+// AdjustCtCtx is like AdjustCt, but it gives up when ctx is done
+func (l *Light) AdjustCtCtx(ctx context.Context, percentage int8, duration string) error {
+	if err := validateTransition("smooth", duration); err != nil {
+		return fmt.Errorf("AdjustCt() failed: %v", err)
+	}
+	if percentage < -100 || percentage > 100 {
+		return fmt.Errorf("AdjustCt() failed: percentage out of range")
+	}
+
+	return l.sendVerifyOnce(ctx, "AdjustCt", "adjust_ct", percentage, json.Number(duration))
+}
+
+/*
+"percentage" the percentage to be adjusted. The range is: -100 ~ 100
+
+"duration" Refer to "set_ct_abx" method.
+
+# From Yeelight's Inter-operation Specification
+
+The light notifies about the resulting color, it's not known before that.
+*/
+func (l *Light) AdjustColor(percentage int8, duration string) error {
+	return l.AdjustColorCtx(context.Background(), percentage, duration)
+}
+
+// AdjustColorCtx is like AdjustColor, but it gives up when ctx is done
+func (l *Light) AdjustColorCtx(ctx context.Context, percentage int8, duration string) error {
+	if err := validateTransition("smooth", duration); err != nil {
+		return fmt.Errorf("AdjustColor() failed: %v", err)
+	}
+	if percentage < -100 || percentage > 100 {
+		return fmt.Errorf("AdjustColor() failed: percentage out of range")
+	}
+
+	return l.sendVerifyOnce(ctx, "AdjustColor", "adjust_color", percentage, json.Number(duration))
 }
 
 /*
@@ -465,9 +763,26 @@ func (l *Light) SetAdjust(action string, prop string) {
 	        1: turn on music mode.
 	    "host" the IP address of the music server.
 	    "port" the TCP port music application is listening on.
+
+Use EnableMusicMode and DisableMusicMode instead, unless you run the music server yourself.
 */
-func (l *Light) SetMusic(action string, host string, port string) {
-	panic("not implemented")
+func (l *Light) SetMusic(action string, host string, port string) error {
+	return l.SetMusicCtx(context.Background(), action, host, port)
+}
+
+// SetMusicCtx is like SetMusic, but it gives up when ctx is done
+func (l *Light) SetMusicCtx(ctx context.Context, action string, host string, port string) error {
+	switch action {
+	case "0":
+		return l.sendVerify(ctx, "SetMusic", "set_music", 0)
+	case "1":
+		portConv, err := strconv.Atoi(port)
+		if err != nil {
+			return fmt.Errorf("SetMusic() failed: port must be an integer")
+		}
+		return l.sendVerify(ctx, "SetMusic", "set_music", 1, host, portConv)
+	}
+	return fmt.Errorf("SetMusic() failed: action must be '0' or '1'")
 }
 
 /*
@@ -493,31 +808,39 @@ range is 1700 ~ 6500 (k).
 
 	The minimum support duration is 30 milliseconds.
 
-From Yeelight's Inter-operation Specification
+# From Yeelight's Inter-operation Specification
+
+The RGB lights without bg_set_ct_abx are sent the color of the black body at the color temperature instead,
+see BgEmulatesCt.
 */
 func (l *Light) BgSetCtAbx(ct_value uint, effect string, duration string) error {
-	if ct_value < 1700 || ct_value > 6500 {
-		return fmt.Errorf("BgSetCtAbx() failed: ct_value out of range")
-	}
-	if effect != "sudden" && effect != "smooth" {
-		return fmt.Errorf("BgSetCtAbx() failed: effect must be 'sudden' or 'smooth'")
-	}
+	return l.BgSetCtAbxCtx(context.Background(), ct_value, effect, duration)
+}
 
-	durationConv, err := strconv.Atoi(duration)
-	if err != nil {
-		return fmt.Errorf("BgSetCtAbx() failed: duration must be an integer")
+// BgSetCtAbxCtx is like BgSetCtAbx, but it gives up when ctx is done
+func (l *Light) BgSetCtAbxCtx(ctx context.Context, ct_value uint, effect string, duration string) error {
+	minCt, maxCt := l.BgCtRange()
+	if ct_value < uint(minCt) || ct_value > uint(maxCt) {
+		return fmt.Errorf("BgSetCtAbx() failed: ct_value out of range (%v - %v)", minCt, maxCt)
 	}
-	if durationConv < 30 {
-		return fmt.Errorf("BgSetCtAbx() failed: duration must be at least 30 ms")
+	err := validateTransition(effect, duration)
+	if err != nil {
+		return fmt.Errorf("BgSetCtAbx() failed: %v", err)
 	}
 
-	err = l.sendVerify("BgSetCtAbx", "{\"id\":0,\"method\":\"bg_set_ct_abx\",\"params\":[%v, \"%v\", %v]}", ct_value, effect, duration)
+	// the requested color temperature is kept even if it's emulated, the rgb only approximates it
+	if l.BgEmulatesCt() {
+		err = l.BgSetRGBCtx(ctx, color.KelvinToRGB(float64(ct_value)).Int(), effect, duration)
+	} else {
+		err = l.sendVerify(ctx, "BgSetCtAbx", "bg_set_ct_abx", ct_value, effect, json.Number(duration))
+	}
 	if err != nil {
 		return err
 	}
 
 	l.stateMutex.Lock()
 	l.latestState.Bg_Ct = uint16(ct_value)
+	l.latestState.Bg_Color_Mode = ColorModeCT
 	l.stateMutex.Unlock()
 	return nil
 }
@@ -544,17 +867,26 @@ func (l *Light) BgSetCtAbx(ct_value uint, effect string, duration string) error
 From Yeelight's Inter-operation Specification
 */
 func (l *Light) BgSetRGB(rgb_value uint32, effect string, duration string) error {
+	return l.BgSetRGBCtx(context.Background(), rgb_value, effect, duration)
+}
+
+// BgSetRGBCtx is like BgSetRGB, but it gives up when ctx is done
+func (l *Light) BgSetRGBCtx(ctx context.Context, rgb_value uint32, effect string, duration string) error {
+	if err := validateTransition(effect, duration); err != nil {
+		return fmt.Errorf("BgSetRGB() failed: %v", err)
+	}
 	if rgb_value > 16777215 {
 		return fmt.Errorf("SetRGB() failed: rgb_value out of range")
 	}
 
-	err := l.sendVerify("BgSetRGB", "{\"id\":0,\"method\":\"bg_set_rgb\",\"params\":[%v, \"%v\", %v]}", rgb_value, effect, duration)
+	err := l.sendVerify(ctx, "BgSetRGB", "bg_set_rgb", rgb_value, effect, json.Number(duration))
 	if err != nil {
 		return err
 	}
 
 	l.stateMutex.Lock()
 	l.latestState.Bg_RGB = rgb_value
+	l.latestState.Bg_Color_Mode = ColorModeRGB
 	l.stateMutex.Unlock()
 	return nil
 }
@@ -583,20 +915,30 @@ func (l *Light) BgSetRGB(rgb_value uint32, effect string, duration string) error
 From Yeelight's Inter-operation Specification
 */
 func (l *Light) BgSetHSV(hue uint16, sat uint8, effect string, duration string) error {
+	return l.BgSetHSVCtx(context.Background(), hue, sat, effect, duration)
+}
+
+// BgSetHSVCtx is like BgSetHSV, but it gives up when ctx is done
+func (l *Light) BgSetHSVCtx(ctx context.Context, hue uint16, sat uint8, effect string, duration string) error {
+	if err := validateTransition(effect, duration); err != nil {
+		return fmt.Errorf("BgSetHSV() failed: %v", err)
+	}
 	if hue > 359 {
-		return fmt.Errorf("SetHSV() failed: hue out of range")
+		return fmt.Errorf("BgSetHSV() failed: hue out of range")
 	}
 	if sat > 100 {
-		return fmt.Errorf("SetHSV() failed: sat out of range")
+		return fmt.Errorf("BgSetHSV() failed: sat out of range")
 	}
 
-	err := l.sendVerify("BgSetHSV", "{\"id\":0,\"method\":\"bg_set_hsv\",\"params\":[%v, %v, \"%v\", %v]}", hue, sat, effect, duration)
+	err := l.sendVerify(ctx, "BgSetHSV", "bg_set_hsv", hue, sat, effect, json.Number(duration))
 	if err != nil {
 		return err
 	}
 
 	l.stateMutex.Lock()
 	l.latestState.Bg_Hue = hue
+	l.latestState.Bg_Sat = sat
+	l.latestState.Bg_Color_Mode = ColorModeHSV
 	l.stateMutex.Unlock()
 	return nil
 }
@@ -627,18 +969,28 @@ func (l *Light) BgSetHSV(hue uint16, sat uint8, effect string, duration string)
 		From Yeelight's Inter-operation Specification
 */
 func (l *Light) BgSetPower(power string, effect string, duration string, mode string) error {
+	return l.BgSetPowerCtx(context.Background(), power, effect, duration, mode)
+}
+
+// BgSetPowerCtx is like BgSetPower, but it gives up when ctx is done
+func (l *Light) BgSetPowerCtx(ctx context.Context, power string, effect string, duration string, mode string) error {
+	if err := validateTransition(effect, duration); err != nil {
+		return fmt.Errorf("BgSetPower() failed: %v", err)
+	}
 	if len(mode) == 0 {
 		mode = "0"
 	}
 
-	err := l.sendVerify("BgSetPower", "{\"id\":0,\"method\":\"bg_set_power\",\"params\":[\"%v\", \"%v\", %v, %v]}", power, effect, duration, mode)
+	err := l.sendVerify(ctx, "BgSetPower", "bg_set_power", power, effect, json.Number(duration), json.Number(mode))
 	if err != nil {
 		return err
 	}
 
 	l.stateMutex.Lock()
 	l.latestState.Bg_On = power == "on"
-	l.latestState.Bg_Color_Mode, _ = ColorModeFromString(mode)
+	if colorMode, ok := colorModeFromPowerMode(mode); ok {
+		l.latestState.Bg_Color_Mode = colorMode
+	}
 	l.stateMutex.Unlock()
 	return nil
 }
@@ -665,11 +1017,19 @@ func (l *Light) BgSetPower(power string, effect string, duration string, mode st
 From Yeelight's Inter-operation Specification
 */
 func (l *Light) BgSetBright(brightness uint8, effect string, duration string) error {
+	return l.BgSetBrightCtx(context.Background(), brightness, effect, duration)
+}
+
+// BgSetBrightCtx is like BgSetBright, but it gives up when ctx is done
+func (l *Light) BgSetBrightCtx(ctx context.Context, brightness uint8, effect string, duration string) error {
+	if err := validateTransition(effect, duration); err != nil {
+		return fmt.Errorf("BgSetBright() failed: %v", err)
+	}
 	if brightness < 1 || brightness > 100 {
 		return fmt.Errorf("SetBright() failed: brightness out of range")
 	}
 
-	err := l.sendVerify("BgSetBright", "{\"id\":0,\"method\":\"bg_set_bright\",\"params\":[%v, \"%v\", %v]}", brightness, effect, duration)
+	err := l.sendVerify(ctx, "BgSetBright", "bg_set_bright", brightness, effect, json.Number(duration))
 	if err != nil {
 		return err
 	}
@@ -679,3 +1039,266 @@ func (l *Light) BgSetBright(brightness uint8, effect string, duration string) er
 	l.stateMutex.Unlock()
 	return nil
 }
+
+/*
+"count" is the total number of visible state changing before color flow stopped.
+
+	0 means infinite loop on the state changing.
+
+"action" is the action taken after the flow is stopped.
+
+	0 means smart LED recover to the state before the color flow started.
+	1 means smart LED stay at the state when the flow is stopped.
+	2 means turn off the smart LED after the flow is stopped.
+
+"flow_expression" is the expression of the state changing series
+
+From Yeelight's Inter-operation Specification
+*/
+func (l *Light) BgStartCf(count uint64, action uint8, flow_expression string) error {
+	return l.BgStartCfCtx(context.Background(), count, action, flow_expression)
+}
+
+// BgStartCfCtx is like BgStartCf, but it gives up when ctx is done
+func (l *Light) BgStartCfCtx(ctx context.Context, count uint64, action uint8, flow_expression string) error {
+	steps, err := ParseFlowExpression(flow_expression)
+	if err != nil {
+		return fmt.Errorf("BgStartCf() failed: %v", err)
+	}
+
+	return l.BgStartFlowCtx(ctx, Flow{Count: count, Action: FlowAction(action), Steps: steps})
+}
+
+// BgStartFlow starts the color flow on the background light, it's BgStartCf with a typed flow
+func (l *Light) BgStartFlow(flow Flow) error {
+	return l.BgStartFlowCtx(context.Background(), flow)
+}
+
+// BgStartFlowCtx is like BgStartFlow, but it gives up when ctx is done
+func (l *Light) BgStartFlowCtx(ctx context.Context, flow Flow) error {
+	err := flow.Validate()
+	if err != nil {
+		return fmt.Errorf("BgStartCf() failed: %v", err)
+	}
+
+	err = l.sendVerify(ctx, "BgStartCf", "bg_start_cf", flow.Count, uint8(flow.Action), flow.Expression())
+	if err != nil {
+		return err
+	}
+
+	l.stateMutex.Lock()
+	l.latestState.Bg_Flowing = true
+	l.latestState.Bg_Flow_Params = flow
+	l.stateMutex.Unlock()
+	return nil
+}
+
+// BgStopCf stops a running color flow on the background light
+func (l *Light) BgStopCf() error {
+	return l.BgStopCfCtx(context.Background())
+}
+
+// BgStopCfCtx is like BgStopCf, but it gives up when ctx is done
+func (l *Light) BgStopCfCtx(ctx context.Context) error {
+	err := l.sendVerify(ctx, "BgStopCf", "bg_stop_cf")
+	if err != nil {
+		return err
+	}
+
+	l.stateMutex.Lock()
+	l.latestState.Bg_Flowing = false
+	l.stateMutex.Unlock()
+	return nil
+}
+
+/*
+"class" can be "color", "hsv", "ct", "cf", "auto_dealy_off".
+
+		"color" means change the smart LED to specified color and brightness.
+	    "hsv" means change the smart LED to specified color and brightness.
+	    "ct" means change the smart LED to specified ct and brightness.
+	    "cf" means start a color flow in specified fashion.
+	    "auto_delay_off" means turn on the smart LED to specified brightness and
+		start a sleep timer to turn off the light after the specified minutes.
+
+"val1", "val2", "val3" are class specific.
+
+# From Yeelight's Inter-operation Specification
+
+The class and its values are given by the type of scene, the background light doesn't support AutoDelayOffScene.
+The RGB lights without bg_set_ct_abx are sent a ColorScene of the color of the black body instead of a CTScene,
+see BgEmulatesCt.
+*/
+func (l *Light) BgSetScene(scene Scene) error {
+	return l.BgSetSceneCtx(context.Background(), scene)
+}
+
+// BgSetSceneCtx is like BgSetScene, but it gives up when ctx is done
+func (l *Light) BgSetSceneCtx(ctx context.Context, scene Scene) error {
+	if _, ok := scene.(AutoDelayOffScene); ok {
+		return fmt.Errorf("BgSetScene() failed: the background light has no sleep timer")
+	}
+
+	params, err := scene.params()
+	if err != nil {
+		return fmt.Errorf("BgSetScene() failed: %v", err)
+	}
+
+	sent := scene
+	if ct, ok := scene.(CTScene); ok {
+		minCt, maxCt := l.BgCtRange()
+		if ct.Ct < minCt || ct.Ct > maxCt {
+			return fmt.Errorf("BgSetScene() failed: ct_value out of range (%v - %v)", minCt, maxCt)
+		}
+		if l.BgEmulatesCt() {
+			sent = ColorScene{RGB: color.KelvinToRGB(float64(ct.Ct)).Int(), Bright: ct.Bright}
+			params, _ = sent.params()
+		}
+	}
+
+	err = l.sendVerify(ctx, "BgSetScene", "bg_set_scene", append([]interface{}{sent.Class()}, params...)...)
+	if err != nil {
+		return err
+	}
+
+	l.stateMutex.Lock()
+	scene.apply(&l.latestState, true)
+	l.stateMutex.Unlock()
+	return nil
+}
+
+/*
+		"action" the direction of the adjustment. The valid value can be:
+			“increase": increase the specified property
+			“decrease": decrease the specified property
+			“circle": increase the specified property, after it reaches the max
+			value, go back to minimum value
+
+		"prop" the property to adjust. The valid value can be:
+	        “bright": adjust brightness.
+			“ct": adjust color temperature.
+	        “color": adjust color. (When “prop" is “color", the “action" can only
+			be “circle", otherwise, it will be deemed as invalid request.)
+
+		From Yeelight's Inter-operation Specification
+*/
+func (l *Light) BgSetAdjust(action string, prop string) error {
+	return l.BgSetAdjustCtx(context.Background(), action, prop)
+}
+
+// BgSetAdjustCtx is like BgSetAdjust, but it gives up when ctx is done
+func (l *Light) BgSetAdjustCtx(ctx context.Context, action string, prop string) error {
+	switch {
+	case action != "increase" && action != "decrease" && action != "circle":
+		return fmt.Errorf("BgSetAdjust() failed: invalid action '%v'", action)
+	case prop != "bright" && prop != "ct" && prop != "color":
+		return fmt.Errorf("BgSetAdjust() failed: invalid prop '%v'", prop)
+	case prop == "color" && action != "circle":
+		return fmt.Errorf("BgSetAdjust() failed: color can only be adjusted using circle")
+	}
+
+	return l.sendVerifyOnce(ctx, "BgSetAdjust", "bg_set_adjust", action, prop)
+}
+
+/*
+"percentage" the percentage to be adjusted. The range is: -100 ~ 100
+
+"duration" Refer to "set_ct_abx" method.
+
+# From Yeelight's Inter-operation Specification
+
+The light notifies about the resulting brightness, it's not known before that.
+*/
+func (l *Light) BgAdjustBright(percentage int8, duration string) error {
+	return l.BgAdjustBrightCtx(context.Background(), percentage, duration)
+}
+
+// BgAdjustBrightCtx is like BgAdjustBright, but it gives up when ctx is done
+func (l *Light) BgAdjustBrightCtx(ctx context.Context, percentage int8, duration string) error {
+	if err := validateTransition("smooth", duration); err != nil {
+		return fmt.Errorf("BgAdjustBright() failed: %v", err)
+	}
+	if percentage < -100 || percentage > 100 {
+		return fmt.Errorf("BgAdjustBright() failed: percentage out of range")
+	}
+
+	return l.sendVerifyOnce(ctx, "BgAdjustBright", "bg_adjust_bright", percentage, json.Number(duration))
+}
+
+/*
+"percentage" the percentage to be adjusted. The range is: -100 ~ 100
+
+"duration" Refer to "set_ct_abx" method.
+
+# From Yeelight's Inter-operation Specification
+
+The light notifies about the resulting color temperature, it's not known before that.
+*/
+func (l *Light) BgAdjustCt(percentage int8, duration string) error {
+	return l.BgAdjustCtCtx(context.Background(), percentage, duration)
+}
+
+// BgAdjustCtCtx is like BgAdjustCt, but it gives up when ctx is done
+func (l *Light) BgAdjustCtCtx(ctx context.Context, percentage int8, duration string) error {
+	if err := validateTransition("smooth", duration); err != nil {
+		return fmt.Errorf("BgAdjustCt() failed: %v", err)
+	}
+	if percentage < -100 || percentage > 100 {
+		return fmt.Errorf("BgAdjustCt() failed: percentage out of range")
+	}
+
+	return l.sendVerifyOnce(ctx, "BgAdjustCt", "bg_adjust_ct", percentage, json.Number(duration))
+}
+
+/*
+"percentage" the percentage to be adjusted. The range is: -100 ~ 100
+
+"duration" Refer to "set_ct_abx" method.
+
+# From Yeelight's Inter-operation Specification
+
+The light notifies about the resulting color, it's not known before that.
+*/
+func (l *Light) BgAdjustColor(percentage int8, duration string) error {
+	return l.BgAdjustColorCtx(context.Background(), percentage, duration)
+}
+
+// BgAdjustColorCtx is like BgAdjustColor, but it gives up when ctx is done
+func (l *Light) BgAdjustColorCtx(ctx context.Context, percentage int8, duration string) error {
+	if err := validateTransition("smooth", duration); err != nil {
+		return fmt.Errorf("BgAdjustColor() failed: %v", err)
+	}
+	if percentage < -100 || percentage > 100 {
+		return fmt.Errorf("BgAdjustColor() failed: percentage out of range")
+	}
+
+	return l.sendVerifyOnce(ctx, "BgAdjustColor", "bg_adjust_color", percentage, json.Number(duration))
+}
+
+// BgToggle toggles the background light
+func (l *Light) BgToggle() error {
+	return l.BgToggleCtx(context.Background())
+}
+
+// BgToggleCtx is like BgToggle, but it gives up when ctx is done
+func (l *Light) BgToggleCtx(ctx context.Context) error {
+	err := l.sendVerify(ctx, "BgToggle", "bg_toggle")
+	if err != nil {
+		return err
+	}
+
+	l.stateMutex.Lock()
+	l.latestState.Bg_On = !l.latestState.Bg_On
+	l.stateMutex.Unlock()
+	return nil
+}
+
+// BgSetDefault saves the current state of the background light in persistent memory, see SetDefault
+func (l *Light) BgSetDefault() error {
+	return l.BgSetDefaultCtx(context.Background())
+}
+
+// BgSetDefaultCtx is like BgSetDefault, but it gives up when ctx is done
+func (l *Light) BgSetDefaultCtx(ctx context.Context) error {
+	return l.sendVerify(ctx, "BgSetDefault", "bg_set_default")
+}
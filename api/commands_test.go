@@ -0,0 +1,35 @@
+package api
+
+import (
+	"github.com/dsorm/yeelight2mqtt/simulator"
+	"testing"
+)
+
+func TestAdjustNotRetried(t *testing.T) {
+	tests := []struct {
+		name   string
+		adjust func(l *Light) error
+		prop   string
+		want   string
+	}{
+		{"main", func(l *Light) error { return l.AdjustBright(10, "500") }, "bright", "60"},
+		{"bg", func(l *Light) error { return l.BgAdjustBright(10, "500") }, "bg_bright", "60"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			bulb, light := newTestLight(t)
+			bulb.Change(map[string]string{test.prop: "50"})
+
+			// the light adjusts the brightness, but the response gets lost, sending it again would adjust it twice
+			bulb.SetFaults(simulator.Faults{LoseResponses: 1})
+			err := test.adjust(light)
+			if err == nil {
+				t.Error("adjustment succeeded without a response")
+			}
+			if got := bulb.Prop(test.prop); got != test.want {
+				t.Errorf("%v of the bulb = %v, want %v", test.prop, got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,198 @@
+package api
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Yeelights listen for search requests (and send their advertisements) on this multicast address
+const ssdpAddress = "239.255.255.250:1982"
+
+const searchRequest = "M-SEARCH * HTTP/1.1\r\n" +
+	"HOST: " + ssdpAddress + "\r\n" +
+	"MAN: \"ssdp:discover\"\r\n" +
+	"ST: wifi_bulb\r\n"
+
+// Advertisement is what a Yeelight tells about itself when answering a search request,
+// or when it announces itself to the network using a NOTIFY message
+type Advertisement struct {
+	ID         string // e.g. 0x000000000015243f
+	Host       string // IP address of the light
+	Port       string // TCP port of the control protocol, usually 55443
+	Model      string // e.g. "color", "mono", "stripe", "ceiling"
+	FwVer      string
+	Support    []string // methods supported by the light
+	Name       string
+	On         bool
+	Bright     uint8
+	Color_Mode ColorMode
+	Ct         uint16
+	RGB        uint32
+	Hue        uint16
+	Sat        uint8
+}
+
+// Discover sends a search request to the local network and collects the answers until timeout expires.
+// Every light appears at most once in the result, even if it answered multiple times.
+func Discover(timeout time.Duration) ([]Advertisement, error) {
+	conn, err := net.ListenPacket("udp4", ":0")
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	dst, err := net.ResolveUDPAddr("udp4", ssdpAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = conn.WriteTo([]byte(searchRequest), dst)
+	if err != nil {
+		return nil, err
+	}
+
+	err = conn.SetReadDeadline(time.Now().Add(timeout))
+	if err != nil {
+		return nil, err
+	}
+
+	var ads []Advertisement
+	seen := make(map[string]bool)
+	buf := make([]byte, 2048)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				break
+			}
+			return ads, err
+		}
+
+		ad, err := ParseAdvertisement(buf[:n])
+		if err != nil {
+			continue
+		}
+
+		if seen[ad.ID] {
+			continue
+		}
+		seen[ad.ID] = true
+		ads = append(ads, ad)
+	}
+
+	return ads, nil
+}
+
+// ListenAdvertisements joins the multicast group Yeelights announce themselves to and calls callback
+// for every advertisement received. It blocks until the socket fails.
+func ListenAdvertisements(callback func(ad Advertisement)) error {
+	group, err := net.ResolveUDPAddr("udp4", ssdpAddress)
+	if err != nil {
+		return err
+	}
+
+	conn, err := net.ListenMulticastUDP("udp4", nil, group)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	buf := make([]byte, 2048)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+
+		// search requests of other clients end up here too, those are just ignored
+		ad, err := ParseAdvertisement(buf[:n])
+		if err != nil {
+			continue
+		}
+		callback(ad)
+	}
+}
+
+// SameID reports whether two Yeelight device IDs refer to the same light,
+// ignoring the case, the 0x prefix and leading zeroes
+func SameID(a string, b string) bool {
+	normalize := func(id string) string {
+		id = strings.ToLower(strings.TrimSpace(id))
+		id = strings.TrimPrefix(id, "0x")
+		return strings.TrimLeft(id, "0")
+	}
+	return a != "" && b != "" && normalize(a) == normalize(b)
+}
+
+// ParseAdvertisement parses a search response (or NOTIFY message) sent by a Yeelight
+func ParseAdvertisement(msg []byte) (Advertisement, error) {
+	ad := Advertisement{}
+	scanner := bufio.NewScanner(strings.NewReader(string(msg)))
+
+	// status line, either "HTTP/1.1 200 OK" or "NOTIFY * HTTP/1.1"
+	if !scanner.Scan() {
+		return ad, fmt.Errorf("ParseAdvertisement() failed: empty message")
+	}
+	statusLine := scanner.Text()
+	if !strings.HasPrefix(statusLine, "HTTP/1.1 200") && !strings.HasPrefix(statusLine, "NOTIFY") {
+		return ad, fmt.Errorf("ParseAdvertisement() failed: unexpected status line '%v'", statusLine)
+	}
+
+	atoi := func(s string) int {
+		num, _ := strconv.Atoi(s)
+		return num
+	}
+
+	for scanner.Scan() {
+		key, value, found := strings.Cut(scanner.Text(), ":")
+		if !found {
+			continue
+		}
+		value = strings.TrimSpace(value)
+
+		switch strings.ToLower(strings.TrimSpace(key)) {
+		case "location":
+			u, err := url.Parse(value)
+			if err != nil || u.Scheme != "yeelight" {
+				return ad, fmt.Errorf("ParseAdvertisement() failed: invalid location '%v'", value)
+			}
+			ad.Host = u.Hostname()
+			ad.Port = u.Port()
+		case "id":
+			ad.ID = value
+		case "model":
+			ad.Model = value
+		case "fw_ver":
+			ad.FwVer = value
+		case "support":
+			ad.Support = strings.Fields(value)
+		case "name":
+			ad.Name = value
+		case "power":
+			ad.On = value == "on"
+		case "bright":
+			ad.Bright = uint8(atoi(value))
+		case "color_mode":
+			ad.Color_Mode, _ = colorModeFromYeelight(uint64(atoi(value)))
+		case "ct":
+			ad.Ct = uint16(atoi(value))
+		case "rgb":
+			ad.RGB = uint32(atoi(value))
+		case "hue":
+			ad.Hue = uint16(atoi(value))
+		case "sat":
+			ad.Sat = uint8(atoi(value))
+		}
+	}
+
+	if ad.ID == "" || ad.Host == "" {
+		return ad, fmt.Errorf("ParseAdvertisement() failed: id or location missing")
+	}
+
+	return ad, nil
+}
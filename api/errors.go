@@ -0,0 +1,70 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	// ErrTimeout means the light didn't respond to the command (or the connection attempt) in time
+	ErrTimeout = errors.New("light didn't respond in time")
+
+	// ErrConnectionRefused means the light refused the connection, usually because LAN control is disabled
+	// or the light already has the maximum number of connections open
+	ErrConnectionRefused = errors.New("light refused the connection")
+
+	// ErrQuotaExceeded means too many commands were sent to the light in the last minute, see CommandQuota and TotalCommandQuota
+	ErrQuotaExceeded = errors.New("command quota of the light exceeded")
+
+	// ErrUnsupportedMethod means the light doesn't know the command, e.g. bg_set_power sent to a light without ambilight
+	ErrUnsupportedMethod = errors.New("method not supported by the light")
+
+	// ErrInvalidProp means the light reported a property value that couldn't be parsed
+	ErrInvalidProp = errors.New("invalid property value")
+
+	// ErrMaxTries means the command failed every time it was sent, see MaxTriesError for the last error
+	ErrMaxTries = errors.New("max tries exceeded")
+)
+
+// CommandError is the error object a light responds with when it can't execute a command, e.g.
+// {"id":1, "error":{"code":-1, "message":"unsupported method"}}
+type CommandError struct {
+	Method  string
+	Code    int
+	Message string
+}
+
+func (e *CommandError) Error() string {
+	return fmt.Sprintf("%v: response from light: %v (code %v)", e.Method, e.Message, e.Code)
+}
+
+// Is lets errors.Is match the error against ErrUnsupportedMethod and ErrQuotaExceeded
+func (e *CommandError) Is(target error) bool {
+	message := strings.ToLower(e.Message)
+	switch target {
+	case ErrUnsupportedMethod:
+		return strings.Contains(message, "unsupported method") || strings.Contains(message, "method not supported")
+	case ErrQuotaExceeded:
+		return strings.Contains(message, "quota")
+	}
+	return false
+}
+
+// MaxTriesError is returned when a command has failed every time it was sent
+type MaxTriesError struct {
+	Tries int
+	Err   error // the error of the last try
+}
+
+func (e *MaxTriesError) Error() string {
+	return fmt.Sprintf("%v (%v), last error: %v", ErrMaxTries, e.Tries, e.Err)
+}
+
+func (e *MaxTriesError) Unwrap() error {
+	return e.Err
+}
+
+func (e *MaxTriesError) Is(target error) bool {
+	return target == ErrMaxTries
+}
@@ -0,0 +1,290 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// FlowMode is the kind of state change done by a FlowStep
+type FlowMode uint8
+
+const (
+	FlowModeColor FlowMode = 1
+	FlowModeCT    FlowMode = 2
+	FlowModeSleep FlowMode = 7
+)
+
+func (fm FlowMode) String() string {
+	switch fm {
+	case FlowModeColor:
+		return "color"
+	case FlowModeCT:
+		return "ct"
+	case FlowModeSleep:
+		return "sleep"
+	}
+	return ""
+}
+
+func (fm FlowMode) MarshalText() ([]byte, error) {
+	if fm.String() == "" {
+		return nil, fmt.Errorf("invalid flow mode %v", uint8(fm))
+	}
+	return []byte(fm.String()), nil
+}
+
+func (fm *FlowMode) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "color":
+		*fm = FlowModeColor
+	case "ct":
+		*fm = FlowModeCT
+	case "sleep":
+		*fm = FlowModeSleep
+	default:
+		return fmt.Errorf("invalid flow mode '%v', must be 'color', 'ct' or 'sleep'", string(text))
+	}
+	return nil
+}
+
+// FlowAction is what the light does after the flow stops
+type FlowAction uint8
+
+const (
+	// FlowActionRecover returns the light to the state before the flow started
+	FlowActionRecover FlowAction = iota
+	// FlowActionStay keeps the light in the state the flow has stopped at
+	FlowActionStay
+	// FlowActionOff turns the light off
+	FlowActionOff
+)
+
+func (fa FlowAction) String() string {
+	switch fa {
+	case FlowActionRecover:
+		return "recover"
+	case FlowActionStay:
+		return "stay"
+	case FlowActionOff:
+		return "off"
+	}
+	return ""
+}
+
+func (fa FlowAction) MarshalText() ([]byte, error) {
+	if fa.String() == "" {
+		return nil, fmt.Errorf("invalid flow action %v", uint8(fa))
+	}
+	return []byte(fa.String()), nil
+}
+
+func (fa *FlowAction) UnmarshalText(text []byte) error {
+	action, err := FlowActionFromString(string(text))
+	if err != nil {
+		return err
+	}
+	*fa = action
+	return nil
+}
+
+func FlowActionFromString(str string) (FlowAction, error) {
+	switch str {
+	case "recover":
+		return FlowActionRecover, nil
+	case "stay":
+		return FlowActionStay, nil
+	case "off":
+		return FlowActionOff, nil
+	}
+	return 0, fmt.Errorf("invalid flow action '%v', must be 'recover', 'stay' or 'off'", str)
+}
+
+/*
+FlowStep is one state change of a color flow.
+
+"Duration" is the time of the gradual change (or of the sleep), in milliseconds, at least 50.
+
+"Mode" is the kind of the change, color (Value is RGB), color temperature (Value is in Kelvin), or sleep.
+
+"Value" is the target RGB value or color temperature, it's ignored in sleep mode.
+
+"Brightness" is the target brightness (1 - 100), -1 keeps the brightness, it's ignored in sleep mode.
+*/
+type FlowStep struct {
+	Duration   uint     `json:"duration"`
+	Mode       FlowMode `json:"mode"`
+	Value      uint32   `json:"value,omitempty"`
+	Brightness int      `json:"brightness,omitempty"`
+}
+
+// Flow is a color flow, a series of state changes the light goes through by itself
+type Flow struct {
+	// Count is the total number of state changes before the flow stops, 0 means the flow never stops
+	Count  uint64     `json:"count"`
+	Action FlowAction `json:"action"`
+	Steps  []FlowStep `json:"steps"`
+}
+
+// Validate checks the flow against the limits from Yeelight's Inter-operation Specification
+func (f Flow) Validate() error {
+	if f.Action > FlowActionOff {
+		return fmt.Errorf("flow action out of range")
+	}
+	if len(f.Steps) == 0 {
+		return fmt.Errorf("flow has no steps")
+	}
+
+	for k, step := range f.Steps {
+		if step.Duration < 50 {
+			return fmt.Errorf("step %v: duration must be at least 50 ms", k+1)
+		}
+
+		switch step.Mode {
+		case FlowModeColor:
+			if step.Value > 0xFFFFFF {
+				return fmt.Errorf("step %v: RGB value out of range", k+1)
+			}
+		case FlowModeCT:
+			if step.Value < 1700 || step.Value > 6500 {
+				return fmt.Errorf("step %v: color temperature out of range (1700 - 6500)", k+1)
+			}
+		case FlowModeSleep:
+			continue
+		default:
+			return fmt.Errorf("step %v: invalid mode %v", k+1, uint8(step.Mode))
+		}
+
+		if step.Brightness != -1 && (step.Brightness < 1 || step.Brightness > 100) {
+			return fmt.Errorf("step %v: brightness must be -1 or between 1 and 100", k+1)
+		}
+	}
+
+	return nil
+}
+
+// Expression returns the steps of the flow in the tuple format used by start_cf,
+// e.g. "1000,1,16711680,100,500,7,0,0"
+func (f Flow) Expression() string {
+	tuples := make([]string, 0, len(f.Steps))
+	for _, step := range f.Steps {
+		value, brightness := step.Value, step.Brightness
+		if step.Mode == FlowModeSleep {
+			value, brightness = 0, 0
+		}
+		tuples = append(tuples, fmt.Sprintf("%v,%v,%v,%v", step.Duration, uint8(step.Mode), value, brightness))
+	}
+	return strings.Join(tuples, ",")
+}
+
+// String returns the flow in the format of the flow_params property, e.g. "0,1,1000,1,16711680,100",
+// or an empty string if the flow has no steps
+func (f Flow) String() string {
+	if len(f.Steps) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%v,%v,%v", f.Count, uint8(f.Action), f.Expression())
+}
+
+// ParseFlowExpression parses the tuple format used by start_cf, e.g. "1000,1,16711680,100,500,7,0,0"
+func ParseFlowExpression(expression string) ([]FlowStep, error) {
+	fields := strings.Split(expression, ",")
+	if len(fields)%4 != 0 {
+		return nil, fmt.Errorf("flow expression must consist of 4-tuples")
+	}
+
+	nums := make([]int64, len(fields))
+	for k, field := range fields {
+		num, err := strconv.ParseInt(strings.TrimSpace(field), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number '%v' in flow expression", field)
+		}
+		nums[k] = num
+	}
+
+	steps := make([]FlowStep, 0, len(nums)/4)
+	for k := 0; k < len(nums); k += 4 {
+		if nums[k] < 0 || nums[k+2] < 0 || nums[k+2] > 0xFFFFFFFF {
+			return nil, fmt.Errorf("invalid tuple %v in flow expression", k/4+1)
+		}
+		// checked before the conversion, which would wrap e.g. 257 around to 1
+		switch mode := nums[k+1]; mode {
+		case int64(FlowModeColor), int64(FlowModeCT), int64(FlowModeSleep):
+		default:
+			return nil, fmt.Errorf("invalid mode %v in tuple %v of flow expression, must be 1, 2 or 7", mode, k/4+1)
+		}
+		steps = append(steps, FlowStep{
+			Duration:   uint(nums[k]),
+			Mode:       FlowMode(nums[k+1]),
+			Value:      uint32(nums[k+2]),
+			Brightness: int(nums[k+3]),
+		})
+	}
+
+	return steps, nil
+}
+
+// ParseFlowParams parses the flow_params and bg_flow_params properties, which are the count and the action
+// followed by the expression, e.g. "0,1,1000,1,16711680,100". A bare expression is accepted as well.
+// An empty string results in a flow without steps.
+func ParseFlowParams(params string) (Flow, error) {
+	flow := Flow{}
+	params = strings.TrimSpace(params)
+	if params == "" {
+		return flow, nil
+	}
+
+	fields := strings.SplitN(params, ",", 3)
+	if strings.Count(params, ",")%4 == 1 && len(fields) == 3 {
+		count, err := strconv.ParseUint(strings.TrimSpace(fields[0]), 10, 64)
+		if err != nil {
+			return flow, fmt.Errorf("invalid count '%v' in flow params", fields[0])
+		}
+		action, err := strconv.ParseUint(strings.TrimSpace(fields[1]), 10, 8)
+		if err != nil {
+			return flow, fmt.Errorf("invalid action '%v' in flow params", fields[1])
+		}
+
+		flow.Count = count
+		flow.Action = FlowAction(action)
+		params = fields[2]
+	}
+
+	steps, err := ParseFlowExpression(params)
+	if err != nil {
+		return flow, err
+	}
+	flow.Steps = steps
+
+	return flow, nil
+}
+
+// ParseFlow parses a flow written either as JSON, e.g.
+//
+//	{"count": 0, "action": "recover", "steps": [{"duration": 1000, "mode": "color", "value": 16711680, "brightness": 100}]}
+//
+// or in the format of the flow_params property. The flow is validated as well.
+// If the brightness of a step is left out from JSON, the brightness isn't changed by that step.
+func ParseFlow(str string) (Flow, error) {
+	var flow Flow
+	var err error
+
+	if strings.HasPrefix(strings.TrimSpace(str), "{") {
+		err = json.Unmarshal([]byte(str), &flow)
+
+		// brightness can be left out to keep it unchanged
+		for k := range flow.Steps {
+			if flow.Steps[k].Brightness == 0 {
+				flow.Steps[k].Brightness = -1
+			}
+		}
+	} else {
+		flow, err = ParseFlowParams(str)
+	}
+	if err != nil {
+		return flow, err
+	}
+
+	return flow, flow.Validate()
+}
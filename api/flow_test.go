@@ -0,0 +1,117 @@
+package api
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseFlowExpression(t *testing.T) {
+	tests := []struct {
+		expression string
+		want       []FlowStep
+		wantErr    bool
+	}{
+		{"1000,1,16711680,100", []FlowStep{{Duration: 1000, Mode: FlowModeColor, Value: 16711680, Brightness: 100}}, false},
+		{"1000, 2, 2700, -1, 500, 7, 0, 0", []FlowStep{
+			{Duration: 1000, Mode: FlowModeCT, Value: 2700, Brightness: -1},
+			{Duration: 500, Mode: FlowModeSleep},
+		}, false},
+		{"1000,1,16711680", nil, true},
+		{"1000,1,red,100", nil, true},
+		{"-1000,1,16711680,100", nil, true},
+		{"1000,1,-1,100", nil, true},
+		{"1000,1,4294967296,100", nil, true},
+		{"1000,3,16711680,100", nil, true},
+		{"1000,0,16711680,100", nil, true},
+		// would be mode 1 if it wrapped around
+		{"1000,257,16711680,100", nil, true},
+		{"1000,-255,16711680,100", nil, true},
+	}
+
+	for _, test := range tests {
+		got, err := ParseFlowExpression(test.expression)
+		if (err != nil) != test.wantErr {
+			t.Errorf("ParseFlowExpression(%q) error = %v, want error %v", test.expression, err, test.wantErr)
+			continue
+		}
+		if !test.wantErr && !reflect.DeepEqual(got, test.want) {
+			t.Errorf("ParseFlowExpression(%q) = %+v, want %+v", test.expression, got, test.want)
+		}
+	}
+}
+
+func TestParseFlowParams(t *testing.T) {
+	tests := []struct {
+		params  string
+		want    Flow
+		wantErr bool
+	}{
+		{"", Flow{}, false},
+		{"0,1,1000,1,16711680,100", Flow{Count: 0, Action: FlowActionStay, Steps: []FlowStep{
+			{Duration: 1000, Mode: FlowModeColor, Value: 16711680, Brightness: 100},
+		}}, false},
+		{"4,2,1000,2,2700,50,500,7,0,0", Flow{Count: 4, Action: FlowActionOff, Steps: []FlowStep{
+			{Duration: 1000, Mode: FlowModeCT, Value: 2700, Brightness: 50},
+			{Duration: 500, Mode: FlowModeSleep},
+		}}, false},
+		// a bare expression
+		{"1000,1,16711680,100", Flow{Steps: []FlowStep{
+			{Duration: 1000, Mode: FlowModeColor, Value: 16711680, Brightness: 100},
+		}}, false},
+		{"x,1,1000,1,16711680,100", Flow{}, true},
+		{"0,256,1000,1,16711680,100", Flow{}, true},
+		{"0,1,1000,257,16711680,100", Flow{}, true},
+	}
+
+	for _, test := range tests {
+		got, err := ParseFlowParams(test.params)
+		if (err != nil) != test.wantErr {
+			t.Errorf("ParseFlowParams(%q) error = %v, want error %v", test.params, err, test.wantErr)
+			continue
+		}
+		if !test.wantErr && !reflect.DeepEqual(got, test.want) {
+			t.Errorf("ParseFlowParams(%q) = %+v, want %+v", test.params, got, test.want)
+		}
+		if !test.wantErr && test.params != "" && got.String() != test.params && got.Expression() != test.params {
+			t.Errorf("ParseFlowParams(%q).String() = %q, want the params", test.params, got.String())
+		}
+	}
+}
+
+func TestParseFlow(t *testing.T) {
+	tests := []struct {
+		str     string
+		want    Flow
+		wantErr bool
+	}{
+		{`{"count": 2, "action": "off", "steps": [{"duration": 1000, "mode": "color", "value": 255, "brightness": 10}]}`, Flow{
+			Count: 2, Action: FlowActionOff, Steps: []FlowStep{{Duration: 1000, Mode: FlowModeColor, Value: 255, Brightness: 10}},
+		}, false},
+		// the brightness is kept if it's left out
+		{`{"steps": [{"duration": 1000, "mode": "ct", "value": 2700}, {"duration": 500, "mode": "sleep"}]}`, Flow{
+			Steps: []FlowStep{{Duration: 1000, Mode: FlowModeCT, Value: 2700, Brightness: -1}, {Duration: 500, Mode: FlowModeSleep, Brightness: -1}},
+		}, false},
+		{"0,0,1000,1,16711680,100", Flow{Steps: []FlowStep{{Duration: 1000, Mode: FlowModeColor, Value: 16711680, Brightness: 100}}}, false},
+		{`{"steps": [{"duration": 1000, "mode": "blink", "value": 255}]}`, Flow{}, true},
+		{`{"action": "repeat", "steps": [{"duration": 1000, "mode": "color", "value": 255}]}`, Flow{}, true},
+		{`{"steps": []}`, Flow{}, true},
+		{`{"steps": [{"duration": 10, "mode": "color", "value": 255}]}`, Flow{}, true},
+		{`{"steps": [{"duration": 1000, "mode": "color", "value": 16777216}]}`, Flow{}, true},
+		{`{"steps": [{"duration": 1000, "mode": "ct", "value": 1000}]}`, Flow{}, true},
+		{`{"steps": [{"duration": 1000, "mode": "color", "value": 255, "brightness": 101}]}`, Flow{}, true},
+		{"0,3,1000,1,16711680,100", Flow{}, true},
+		{"0,0,1000,257,16711680,100", Flow{}, true},
+		{"", Flow{}, true},
+	}
+
+	for _, test := range tests {
+		got, err := ParseFlow(test.str)
+		if (err != nil) != test.wantErr {
+			t.Errorf("ParseFlow(%q) error = %v, want error %v", test.str, err, test.wantErr)
+			continue
+		}
+		if !test.wantErr && !reflect.DeepEqual(got, test.want) {
+			t.Errorf("ParseFlow(%q) = %+v, want %+v", test.str, got, test.want)
+		}
+	}
+}
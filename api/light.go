@@ -6,19 +6,45 @@ import (
 )
 
 type Light struct {
+	// Host is the address the light is reachable at. It may be omitted if ID is set, in which case it's
+	// resolved (and kept up to date) using the advertisements of the light.
+	// The port may be appended (e.g. 192.168.1.10:55443), 55443 is used otherwise
 	Host string
 	Name string
+	// ID is the Yeelight device ID, e.g. 0x000000000015243f, it's filled in by discovery if omitted
+	ID string
+	// Model is the Yeelight model, e.g. "color" or "ceiling4", it's filled in by discovery if omitted
+	Model string
+	// Support is the list of the methods the light supports, it's filled in by discovery if omitted.
+	// Every method is assumed to be supported if it's empty.
+	Support []string
+	// Music enables the music mode, in which the commands are sent over a connection without the command quota
+	Music bool
+	// Transition is the transition used by Apply if the change doesn't have one, DefaultTransition is used if
+	// it's not set either
+	Transition Transition
+
+	// the light may be described by discovery while it's in use, see Describe
+	describeMutex sync.Mutex // guards ID, Model and Support
 
 	stateMutex  sync.Mutex
 	latestState LightProperties
-	conn        net.Conn
-	connMutex   sync.Mutex
+	dayState    *LightProperties // state before EnableMoonlight, guarded by stateMutex
+
+	// There is only one connection to the light, commands are written to it by SendCommand, and everything the light
+	// sends back is read by readLoop, which hands the responses over to the waiting commands (matched by the id of
+	// the command) and passes the notifications about changed props to refreshCallback
+	conn      net.Conn
+	connDone  chan struct{} // closed when readLoop of conn exits
+	pending   map[uint64]chan response
+	connMutex sync.Mutex // guards Host, conn, connDone and pending
+	commandID uint64     // id of the last command sent, accessed atomically
+	scheduler scheduler
 
-	// When there is a change in Yeelight props, it is automatically sent by the light back to yeelight2mqtt
-	// yeelight2mqtt only reads from the connection when a request is made or because of automatic polling,
-	// so this channel is used to get rid of the data (when handling another request), so a dedicated goroutine may handle it
-	refreshMsg      chan string
-	refreshCallback func(message string)
+	// connection the light has opened to the music server when music mode was enabled, guarded by connMutex
+	musicConn net.Conn
+
+	refreshCallback func(props []string)
 }
 
 type LightProperties struct {
@@ -28,15 +54,15 @@ type LightProperties struct {
 	RGB            uint32    // (range 0 - 16777215)
 	Hue            uint16    // (range 0 - 359)
 	Sat            uint8     // (range 0 - 100)
-	Color_Mode     ColorMode // 0: RGB mode, 1: Color temperature mode, 2: HSV mode, 3: Flow mode
+	Color_Mode     ColorMode // RGB, CT or HSV (the light reports 1, 2 and 3)
 	Flowing        bool
 	Delayoff       uint8 // (range 1 - 60 minutes)
-	Flow_Params    string
+	Flow_Params    Flow
 	Music_On       bool
 	Name           string
 	Bg_On          bool
 	Bg_Flowing     bool
-	Bg_Flow_Params string
+	Bg_Flow_Params Flow
 	Bg_Ct          uint16
 	Bg_Color_Mode  ColorMode
 	Bg_Bright      uint8
@@ -45,6 +71,14 @@ type LightProperties struct {
 	Bg_Sat         uint8
 	Nl_Br          uint8 // (range 1 - 100)
 	Moonlight_On   bool
+	Main_Power     bool  // whether the main light is on, of lights with an ambilight
+	Bg_Proact      bool  // whether the ambilight turns on and off together with the main light
+	Lan_Ctrl       bool  // whether LAN control is enabled
+	Save_State     bool  // whether the light restores its last state when powered on using a wall switch
+	Init_Power_On  uint8 // what the light does when powered on using a wall switch, 1: turn on, 2: stay off
+
+	// Present tells which of the properties have been reported by the light, the others are zero
+	Present PropSet
 }
 
 type ColorMode uint8
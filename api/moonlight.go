@@ -0,0 +1,185 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// MoonlightOn asks the light whether it's in moonlight (night light) mode, which only ceiling lights have.
+// The brightness of the moonlight is refreshed as well.
+func (l *Light) MoonlightOn() (bool, error) {
+	return l.MoonlightOnCtx(context.Background())
+}
+
+// MoonlightOnCtx is like MoonlightOn, but it gives up when ctx is done
+func (l *Light) MoonlightOnCtx(ctx context.Context) (bool, error) {
+	result, err := l.SendCommandWithPriorityCtx(ctx, PriorityHigh, "get_prop", []interface{}{"active_mode", "nl_br"}, 3)
+	if err != nil {
+		return false, err
+	}
+	if len(result) != 2 {
+		return false, fmt.Errorf("MoonlightOn() failed: unexpected result %v", result)
+	}
+
+	// lights without moonlight answer with empty strings
+	l.stateMutex.Lock()
+	defer l.stateMutex.Unlock()
+	for k, name := range []string{"active_mode", "nl_br"} {
+		if value := propString(result[k]); value != "" {
+			_, err = l.latestState.set(name, value)
+			if err != nil {
+				return false, fmt.Errorf("MoonlightOn() failed: %v", err)
+			}
+		}
+	}
+
+	return l.latestState.Moonlight_On, nil
+}
+
+// EnableMoonlight turns the light on in moonlight mode. The state of the daylight is remembered,
+// DisableMoonlight switches back to it.
+func (l *Light) EnableMoonlight(effect string, duration string) error {
+	return l.EnableMoonlightCtx(context.Background(), effect, duration)
+}
+
+// EnableMoonlightCtx is like EnableMoonlight, but it gives up when ctx is done
+func (l *Light) EnableMoonlightCtx(ctx context.Context, effect string, duration string) error {
+	state := l.GetState()
+
+	// 5 is the night light mode of set_power
+	err := l.SetPowerCtx(ctx, "on", effect, duration, "5")
+	if err != nil {
+		return err
+	}
+
+	l.stateMutex.Lock()
+	if !state.Moonlight_On && state.On {
+		l.dayState = &state
+	}
+	l.latestState.Moonlight_On = true
+	l.stateMutex.Unlock()
+	return nil
+}
+
+// DisableMoonlight switches the light from moonlight to the daylight it had before EnableMoonlight,
+// or to the color temperature mode if the daylight isn't known. The light is left alone if it isn't in moonlight.
+func (l *Light) DisableMoonlight(effect string, duration string) error {
+	return l.DisableMoonlightCtx(context.Background(), effect, duration)
+}
+
+// DisableMoonlightCtx is like DisableMoonlight, but it gives up when ctx is done
+func (l *Light) DisableMoonlightCtx(ctx context.Context, effect string, duration string) error {
+	if err := validateTransition(effect, duration); err != nil {
+		return fmt.Errorf("DisableMoonlight() failed: %v", err)
+	}
+
+	// the light might have left moonlight already (e.g. using the remote), it would be turned on otherwise
+	moonlight, err := l.MoonlightOnCtx(ctx)
+	if err != nil {
+		return err
+	}
+
+	l.stateMutex.Lock()
+	day := l.dayState
+	l.dayState = nil
+	l.stateMutex.Unlock()
+	if !moonlight {
+		return nil
+	}
+
+	// the light keeps the daylight while it's in moonlight, set_power brings it back using the transition
+	// (unlike set_scene). 1 turns the light on in the color temperature mode, 2 in RGB and 3 in HSV.
+	mode := "1"
+	if day != nil {
+		switch day.Color_Mode {
+		case ColorModeRGB:
+			mode = "2"
+		case ColorModeHSV:
+			mode = "3"
+		}
+	}
+
+	err = l.SetPowerCtx(ctx, "on", effect, duration, mode)
+	if err != nil {
+		return err
+	}
+
+	l.stateMutex.Lock()
+	l.latestState.Moonlight_On = false
+	l.stateMutex.Unlock()
+	return nil
+}
+
+/*
+SetMoonlightBright changes the brightness of the moonlight, the light has to be in moonlight mode already.
+
+"brightness" is the target brightness (1 - 100), the other parameters are the same as in SetBright.
+*/
+func (l *Light) SetMoonlightBright(brightness uint8, effect string, duration string) error {
+	return l.SetMoonlightBrightCtx(context.Background(), brightness, effect, duration)
+}
+
+// SetMoonlightBrightCtx is like SetMoonlightBright, but it gives up when ctx is done
+func (l *Light) SetMoonlightBrightCtx(ctx context.Context, brightness uint8, effect string, duration string) error {
+	if err := validateTransition(effect, duration); err != nil {
+		return fmt.Errorf("SetMoonlightBright() failed: %v", err)
+	}
+	if brightness < 1 || brightness > 100 {
+		return fmt.Errorf("SetMoonlightBright() failed: brightness out of range")
+	}
+
+	// set_bright changes the daylight brightness outside of moonlight mode, the cached state might be stale
+	moonlight, err := l.MoonlightOnCtx(ctx)
+	if err != nil {
+		return err
+	}
+	if !moonlight {
+		return fmt.Errorf("SetMoonlightBright() failed: the light isn't in moonlight mode")
+	}
+
+	return l.setMoonlightBright(ctx, "SetMoonlightBright", brightness, effect, duration)
+}
+
+// EnableMoonlightBright changes the brightness of the moonlight like SetMoonlightBright, the light is switched
+// to moonlight mode first if it isn't in it
+func (l *Light) EnableMoonlightBright(brightness uint8, effect string, duration string) error {
+	return l.EnableMoonlightBrightCtx(context.Background(), brightness, effect, duration)
+}
+
+// EnableMoonlightBrightCtx is like EnableMoonlightBright, but it gives up when ctx is done
+func (l *Light) EnableMoonlightBrightCtx(ctx context.Context, brightness uint8, effect string, duration string) error {
+	if err := validateTransition(effect, duration); err != nil {
+		return fmt.Errorf("EnableMoonlightBright() failed: %v", err)
+	}
+	if brightness < 1 || brightness > 100 {
+		return fmt.Errorf("EnableMoonlightBright() failed: brightness out of range")
+	}
+
+	moonlight, err := l.MoonlightOnCtx(ctx)
+	if err != nil {
+		return err
+	}
+	if !moonlight {
+		err = l.EnableMoonlightCtx(ctx, effect, duration)
+		if err != nil {
+			return err
+		}
+	}
+
+	return l.setMoonlightBright(ctx, "EnableMoonlightBright", brightness, effect, duration)
+}
+
+// setMoonlightBright sends set_bright to a light known to be in moonlight mode, funcName is used in the errors
+func (l *Light) setMoonlightBright(ctx context.Context, funcName string, brightness uint8, effect string, duration string) error {
+	// SetBright would change the daylight brightness in the state
+	err := l.sendVerify(ctx, funcName, "set_bright", brightness, effect, json.Number(duration))
+	if err != nil {
+		return err
+	}
+
+	l.stateMutex.Lock()
+	l.latestState.Nl_Br = brightness
+	l.stateMutex.Unlock()
+	return nil
+}
@@ -0,0 +1,94 @@
+package api
+
+import (
+	"testing"
+)
+
+func TestDisableMoonlight(t *testing.T) {
+	tests := []struct {
+		name      string
+		props     map[string]string
+		wantProps map[string]string
+	}{
+		{"back to rgb", map[string]string{"power": "on", "color_mode": "1"}, map[string]string{"power": "on", "active_mode": "0", "color_mode": "1"}},
+		{"back to hsv", map[string]string{"power": "on", "color_mode": "3"}, map[string]string{"power": "on", "active_mode": "0", "color_mode": "3"}},
+		{"daylight unknown", map[string]string{"power": "off", "color_mode": "1"}, map[string]string{"power": "on", "active_mode": "0", "color_mode": "2"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			bulb, light := newTestLight(t)
+			bulb.Change(test.props)
+
+			err := light.GetProp()
+			if err != nil {
+				t.Fatalf("GetProp() failed: %v", err)
+			}
+			err = light.EnableMoonlight("sudden", "0")
+			if err != nil {
+				t.Fatalf("EnableMoonlight() failed: %v", err)
+			}
+
+			err = light.DisableMoonlight("smooth", "500")
+			if err != nil {
+				t.Fatalf("DisableMoonlight() failed: %v", err)
+			}
+			for name, want := range test.wantProps {
+				if got := bulb.Prop(name); got != want {
+					t.Errorf("%v of the bulb = %v, want %v", name, got, want)
+				}
+			}
+			if light.GetState().Moonlight_On {
+				t.Error("Moonlight_On = true after DisableMoonlight()")
+			}
+		})
+	}
+}
+
+func TestDisableMoonlightOutsideMoonlight(t *testing.T) {
+	bulb, light := newTestLight(t)
+	bulb.Change(map[string]string{"power": "off"})
+
+	// the cached state is stale, e.g. the light left moonlight using the remote
+	err := light.EnableMoonlight("sudden", "0")
+	if err != nil {
+		t.Fatalf("EnableMoonlight() failed: %v", err)
+	}
+	bulb.Change(map[string]string{"power": "off", "active_mode": "0"})
+
+	err = light.DisableMoonlight("sudden", "0")
+	if err != nil {
+		t.Fatalf("DisableMoonlight() failed: %v", err)
+	}
+	if power := bulb.Prop("power"); power != "off" {
+		t.Errorf("power of the bulb = %v, want off", power)
+	}
+}
+
+func TestEnableMoonlightBright(t *testing.T) {
+	bulb, light := newTestLight(t)
+	bulb.Change(map[string]string{"power": "on", "bright": "80"})
+
+	err := light.GetProp()
+	if err != nil {
+		t.Fatalf("GetProp() failed: %v", err)
+	}
+	err = light.EnableMoonlightBright(10, "sudden", "0")
+	if err != nil {
+		t.Fatalf("EnableMoonlightBright() failed: %v", err)
+	}
+	if mode, nlBr, bright := bulb.Prop("active_mode"), bulb.Prop("nl_br"), bulb.Prop("bright"); mode != "1" || nlBr != "10" || bright != "80" {
+		t.Errorf("active_mode, nl_br and bright of the bulb = %v, %v and %v, want 1, 10 and 80", mode, nlBr, bright)
+	}
+	if state := light.GetState(); !state.Moonlight_On || state.Nl_Br != 10 || state.Bright != 80 {
+		t.Errorf("GetState() = %+v, want moonlight on, nl_br 10 and bright 80", state)
+	}
+
+	err = light.SetMoonlightBright(20, "sudden", "0")
+	if err != nil {
+		t.Fatalf("SetMoonlightBright() failed: %v", err)
+	}
+	if nlBr := bulb.Prop("nl_br"); nlBr != "20" {
+		t.Errorf("nl_br of the bulb = %v, want 20", nlBr)
+	}
+}
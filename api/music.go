@@ -0,0 +1,177 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/dsorm/yeelight2mqtt/console"
+	"io"
+	"net"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// how long to wait for the light to connect to the music server
+const musicConnectTimeout = 5 * time.Second
+
+// EnableMusicMode starts a music server, and asks the light to connect to it using set_music.
+// Until the music mode is disabled (or the light closes the connection), commands changing the state of the light
+// are sent over this connection. There is no quota on it, but the light doesn't respond to the commands either.
+func (l *Light) EnableMusicMode() error {
+	return l.EnableMusicModeCtx(context.Background())
+}
+
+// EnableMusicModeCtx is like EnableMusicMode, but it gives up when ctx is done
+func (l *Light) EnableMusicModeCtx(ctx context.Context) error {
+	if l.MusicMode() {
+		return nil
+	}
+
+	// the music server has to listen on the address the light can reach us at
+	conn, _, err := l.connect(ctx)
+	if err != nil {
+		return fmt.Errorf("EnableMusicMode() failed: %w", err)
+	}
+	localAddr, ok := conn.LocalAddr().(*net.TCPAddr)
+	if !ok {
+		return fmt.Errorf("EnableMusicMode() failed: unexpected local address %v", conn.LocalAddr())
+	}
+
+	listener, err := net.ListenTCP("tcp", &net.TCPAddr{IP: localAddr.IP})
+	if err != nil {
+		return fmt.Errorf("EnableMusicMode() failed: %w", err)
+	}
+	defer listener.Close()
+
+	deadline := time.Now().Add(musicConnectTimeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	err = listener.SetDeadline(deadline)
+	if err != nil {
+		return fmt.Errorf("EnableMusicMode() failed: %w", err)
+	}
+
+	type accepted struct {
+		conn net.Conn
+		err  error
+	}
+	acceptChan := make(chan accepted, 1)
+	go func() {
+		conn, err := listener.Accept()
+		acceptChan <- accepted{conn, err}
+	}()
+
+	port := listener.Addr().(*net.TCPAddr).Port
+	err = l.SetMusicCtx(ctx, "1", localAddr.IP.String(), strconv.Itoa(port))
+	if err != nil {
+		return err
+	}
+
+	a := <-acceptChan
+	if a.err != nil {
+		return fmt.Errorf("EnableMusicMode() failed: light didn't connect to the music server: %w", a.err)
+	}
+
+	l.connMutex.Lock()
+	l.musicConn = a.conn
+	l.connMutex.Unlock()
+	go l.musicReadLoop(a.conn)
+
+	l.stateMutex.Lock()
+	l.latestState.Music_On = true
+	l.stateMutex.Unlock()
+	return nil
+}
+
+// DisableMusicMode stops the music mode, the commands are sent over the regular connection again
+func (l *Light) DisableMusicMode() error {
+	return l.DisableMusicModeCtx(context.Background())
+}
+
+// DisableMusicModeCtx is like DisableMusicMode, but it gives up when ctx is done
+func (l *Light) DisableMusicModeCtx(ctx context.Context) error {
+	err := l.SetMusicCtx(ctx, "0", "", "")
+
+	l.dropMusicConn()
+	return err
+}
+
+// MusicMode reports whether the commands are currently sent over the music connection
+func (l *Light) MusicMode() bool {
+	l.connMutex.Lock()
+	defer l.connMutex.Unlock()
+	return l.musicConn != nil
+}
+
+// sendMusic sends the command over the music connection, returns false if it couldn't be sent that way
+func (l *Light) sendMusic(ctx context.Context, method string, params []interface{}) bool {
+	// these need a response, which the light doesn't send over the music connection
+	switch method {
+	case "get_prop", "cron_get", "set_music":
+		return false
+	}
+
+	l.connMutex.Lock()
+	conn := l.musicConn
+	l.connMutex.Unlock()
+	if conn == nil || ctx.Err() != nil {
+		return false
+	}
+
+	msg, err := json.Marshal(command{
+		ID:     atomic.AddUint64(&l.commandID, 1),
+		Method: method,
+		Params: params,
+	})
+	if err != nil {
+		return false
+	}
+
+	err = conn.SetWriteDeadline(writeDeadline(ctx))
+	if err == nil {
+		_, err = conn.Write(append(msg, '\r', '\n'))
+	}
+	if err != nil {
+		console.Logf("Music connection to light '%v' failed, falling back to the regular connection: %v\n", l.Name, err)
+		l.dropMusicConn()
+		return false
+	}
+
+	return true
+}
+
+// musicReadLoop waits until the light closes the music connection
+func (l *Light) musicReadLoop(conn net.Conn) {
+	_, _ = io.Copy(io.Discard, conn)
+
+	l.connMutex.Lock()
+	dropped := l.musicConn == conn
+	l.connMutex.Unlock()
+
+	if dropped {
+		console.Logf("Light '%v' closed the music connection, falling back to the regular connection\n", l.Name)
+		l.dropMusicConn()
+	}
+}
+
+func (l *Light) dropMusicConn() {
+	l.connMutex.Lock()
+	conn := l.musicConn
+	l.musicConn = nil
+	l.connMutex.Unlock()
+
+	if conn == nil {
+		return
+	}
+	conn.Close()
+
+	l.stateMutex.Lock()
+	l.latestState.Music_On = false
+	l.stateMutex.Unlock()
+
+	if l.refreshCallback != nil {
+		l.refreshCallback([]string{"music_on"})
+	}
+}
@@ -0,0 +1,75 @@
+package api
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestMusicMode(t *testing.T) {
+	bulb, light := newTestLight(t)
+
+	err := light.EnableMusicMode()
+	if err != nil {
+		t.Fatalf("EnableMusicMode() failed: %v", err)
+	}
+	if !light.MusicMode() || !light.GetState().Music_On {
+		t.Error("MusicMode() = false after EnableMusicMode()")
+	}
+	eventually(t, bulb.MusicMode, "bulb didn't connect to the music server")
+
+	// there's no quota on the music connection
+	for k := 1; k <= CommandQuota+10; k++ {
+		err = light.SetBright(uint8(k%100+1), "sudden", "30")
+		if err != nil {
+			t.Fatalf("SetBright() %v failed: %v", k, err)
+		}
+	}
+	eventually(t, func() bool { return bulb.Prop("bright") == "71" }, "bulb didn't get the commands sent over the music connection")
+
+	// the commands needing a response still use the regular connection
+	err = light.GetProp()
+	if errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("GetProp() error = %v, the commands sent over the music connection were counted", err)
+	}
+	if err != nil {
+		t.Fatalf("GetProp() failed: %v", err)
+	}
+
+	err = light.DisableMusicMode()
+	if err != nil {
+		t.Fatalf("DisableMusicMode() failed: %v", err)
+	}
+	if light.MusicMode() || light.GetState().Music_On {
+		t.Error("MusicMode() = true after DisableMusicMode()")
+	}
+	eventually(t, func() bool { return !bulb.MusicMode() }, "bulb didn't close the music connection")
+}
+
+func TestMusicModeClosedByLight(t *testing.T) {
+	bulb, light := newTestLight(t)
+
+	changed := make(chan []string, 10)
+	light.SetRefreshCallback(func(props []string) { changed <- props })
+
+	err := light.EnableMusicMode()
+	if err != nil {
+		t.Fatalf("EnableMusicMode() failed: %v", err)
+	}
+	eventually(t, bulb.MusicMode, "bulb didn't connect to the music server")
+
+	// e.g. the light was turned off using a wall switch
+	_, err = light.SendCommand("set_music", []interface{}{0}, 1)
+	if err != nil {
+		t.Fatalf("SendCommand() failed: %v", err)
+	}
+	eventually(t, func() bool { return !light.MusicMode() }, "MusicMode() = true after the bulb closed the music connection")
+
+	// the commands fall back to the regular connection
+	err = light.SetBright(42, "sudden", "30")
+	if err != nil {
+		t.Fatalf("SetBright() failed: %v", err)
+	}
+	if bright := bulb.Prop("bright"); bright != "42" {
+		t.Errorf("bright of the bulb = %v, want 42", bright)
+	}
+}
@@ -0,0 +1,188 @@
+package api
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// Yeelights usually send property values as strings, but some firmwares send numbers in notifications
+func propString(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case nil:
+		return ""
+	}
+	return fmt.Sprint(value)
+}
+
+// propNames are the properties (as used by get_prop) tracked in LightProperties
+var propNames = []string{"power", "bright", "ct", "rgb", "hue", "sat", "color_mode", "flowing", "delayoff", "flow_params", "music_on", "name", "bg_power", "bg_flowing", "bg_flow_params", "bg_ct", "bg_lmode", "bg_bright", "bg_rgb", "bg_hue", "bg_sat", "nl_br", "active_mode", "main_power", "bg_proact", "lan_ctrl", "save_state", "init_power_on"}
+
+// PropSet is a set of the properties from propNames, it tells which properties the light has reported.
+// Lights answer with an empty string for the properties they don't have, e.g. rgb of a mono bulb.
+type PropSet uint64
+
+// Has reports whether the property called name (as used by get_prop) is in the set
+func (ps PropSet) Has(name string) bool {
+	k := propIndex(name)
+	return k >= 0 && ps&(1<<k) != 0
+}
+
+func (ps *PropSet) add(name string) {
+	if k := propIndex(name); k >= 0 {
+		*ps |= 1 << k
+	}
+}
+
+func (ps *PropSet) remove(name string) {
+	if k := propIndex(name); k >= 0 {
+		*ps &^= 1 << k
+	}
+}
+
+// propIndex returns the index of the property in propNames, or -1 if it isn't there
+func propIndex(name string) int {
+	for k, propName := range propNames {
+		if propName == name {
+			return k
+		}
+	}
+	return -1
+}
+
+// colorModeFromYeelight converts the color_mode and bg_lmode properties, which are 1 for RGB, 2 for CT and 3 for HSV
+func colorModeFromYeelight(num uint64) (ColorMode, error) {
+	switch num {
+	case 1:
+		return ColorModeRGB, nil
+	case 2:
+		return ColorModeCT, nil
+	case 3:
+		return ColorModeHSV, nil
+	}
+	return 0, fmt.Errorf("unknown color mode %v", num)
+}
+
+// colorModeFromPowerMode converts the mode of set_power, 0 (normal) and 5 (night light) don't change the color mode
+func colorModeFromPowerMode(mode string) (ColorMode, bool) {
+	switch mode {
+	case "1":
+		return ColorModeCT, true
+	case "2":
+		return ColorModeRGB, true
+	case "3":
+		return ColorModeHSV, true
+	case "4":
+		return ColorModeFlow, true
+	}
+	return 0, false
+}
+
+// set changes the property called name (as used by get_prop) to value, an empty value means the light doesn't
+// have the property. Returns false if the property isn't tracked in LightProperties.
+func (lp *LightProperties) set(name string, value string) (bool, error) {
+	if value == "" {
+		lp.Present.remove(name)
+		return propIndex(name) >= 0, nil
+	}
+
+	var num uint64
+	var flag bool
+	var err error
+	switch name {
+	case "power", "bg_power", "main_power":
+		flag, err = parseFlag(value, "on", "off")
+	case "flowing", "music_on", "bg_flowing", "active_mode", "bg_proact", "lan_ctrl", "save_state":
+		flag, err = parseFlag(value, "1", "0")
+	case "bright", "sat", "color_mode", "delayoff", "bg_lmode", "bg_bright", "bg_sat", "nl_br", "init_power_on":
+		num, err = strconv.ParseUint(value, 10, 8)
+	case "ct", "hue", "bg_ct", "bg_hue":
+		num, err = strconv.ParseUint(value, 10, 16)
+	case "rgb", "bg_rgb":
+		num, err = strconv.ParseUint(value, 10, 32)
+	}
+	if err != nil {
+		return true, fmt.Errorf("invalid value '%v' of property '%v': %v", value, name, err)
+	}
+
+	switch name {
+	case "power":
+		lp.On = flag
+	case "bright":
+		lp.Bright = uint8(num)
+	case "ct":
+		lp.Ct = uint16(num)
+	case "rgb":
+		lp.RGB = uint32(num)
+	case "hue":
+		lp.Hue = uint16(num)
+	case "sat":
+		lp.Sat = uint8(num)
+	case "color_mode":
+		lp.Color_Mode, err = colorModeFromYeelight(num)
+	case "flowing":
+		lp.Flowing = flag
+	case "delayoff":
+		lp.Delayoff = uint8(num)
+	case "flow_params":
+		lp.Flow_Params, err = ParseFlowParams(value)
+	case "music_on":
+		lp.Music_On = flag
+	case "name":
+		lp.Name = value
+	case "bg_power":
+		lp.Bg_On = flag
+	case "bg_flowing":
+		lp.Bg_Flowing = flag
+	case "bg_flow_params":
+		lp.Bg_Flow_Params, err = ParseFlowParams(value)
+	case "bg_ct":
+		lp.Bg_Ct = uint16(num)
+	case "bg_lmode":
+		lp.Bg_Color_Mode, err = colorModeFromYeelight(num)
+	case "bg_bright":
+		lp.Bg_Bright = uint8(num)
+	case "bg_rgb":
+		lp.Bg_RGB = uint32(num)
+	case "bg_hue":
+		lp.Bg_Hue = uint16(num)
+	case "bg_sat":
+		lp.Bg_Sat = uint8(num)
+	case "nl_br":
+		lp.Nl_Br = uint8(num)
+	case "active_mode":
+		lp.Moonlight_On = flag
+	case "main_power":
+		lp.Main_Power = flag
+	case "bg_proact":
+		lp.Bg_Proact = flag
+	case "lan_ctrl":
+		lp.Lan_Ctrl = flag
+	case "save_state":
+		lp.Save_State = flag
+	case "init_power_on":
+		lp.Init_Power_On = uint8(num)
+	default:
+		return false, nil
+	}
+	if err != nil {
+		return true, fmt.Errorf("invalid value '%v' of property '%v': %v", value, name, err)
+	}
+
+	lp.Present.add(name)
+	return true, nil
+}
+
+// parseFlag parses the value of a property that's either on or off, e.g. "on"/"off" of power or "1"/"0" of flowing
+func parseFlag(value string, on string, off string) (bool, error) {
+	switch value {
+	case on:
+		return true, nil
+	case off:
+		return false, nil
+	}
+	return false, fmt.Errorf("must be '%v' or '%v'", on, off)
+}
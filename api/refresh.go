@@ -1,27 +1,72 @@
 package api
 
-import "github.com/dsorm/yeelight2mqtt/console"
+import (
+	"context"
+	"github.com/dsorm/yeelight2mqtt/console"
+	"time"
+)
 
-func (l *Light) SetRefreshCallback(callback func(message string)) {
-	l.refreshCallback = callback
-}
+const (
+	minReconnectDelay = 5 * time.Second
+	maxReconnectDelay = 5 * time.Minute
+)
 
-func RunRefreshDaemons(lights *[]Light) {
-	deref := *lights
-	for k := range deref {
-		go deref[k].RefreshDaemon()
-	}
+// SetRefreshCallback sets the function called when the light notifies about a change of its properties.
+// props contains the names of the changed properties (as used by get_prop), the new values are already in GetState()
+func (l *Light) SetRefreshCallback(callback func(props []string)) {
+	l.refreshCallback = callback
 }
 
+// RefreshDaemon keeps the connection to the light open, so notifications about changed props are received
+// even when no commands are being sent
 func (l *Light) RefreshDaemon() {
+	// the delay doubles with every failed connection attempt, so an unplugged light isn't hammered
+	delay := minReconnectDelay
 	for {
-		select {
-		case msg := <-l.refreshMsg:
-			if l.refreshCallback != nil {
-				l.refreshCallback(msg)
-			} else {
-				console.Logf("Error: refresh callback not set for light %s", l.Name)
+		_, done, err := l.connect(context.Background())
+		if err != nil {
+			time.Sleep(delay)
+			delay *= 2
+			if delay > maxReconnectDelay {
+				delay = maxReconnectDelay
 			}
+			continue
 		}
+
+		delay = minReconnectDelay
+		<-done
+		time.Sleep(time.Second)
+	}
+}
+
+// Process {"method":"props","params":{"power":"on", "bright":"10"}}, update the state and let the callback know
+func (l *Light) handleNotification(notification response) {
+	if notification.Method != "props" {
+		return
+	}
+
+	changed := make([]string, 0, len(notification.Params))
+	l.stateMutex.Lock()
+	previous := l.latestState
+	for name, value := range notification.Params {
+		known, err := l.latestState.set(name, propString(value))
+		if err != nil {
+			console.Logf("Ignoring property '%v' from light '%v': %v\n", name, l.Name, err)
+			continue
+		}
+		if known {
+			changed = append(changed, name)
+		}
+	}
+	l.keepEmulatedCt(previous, &l.latestState)
+	l.stateMutex.Unlock()
+
+	if len(changed) == 0 {
+		return
+	}
+	if l.refreshCallback != nil {
+		l.refreshCallback(changed)
+	} else {
+		console.Logf("Error: refresh callback not set for light %s\n", l.Name)
 	}
 }
@@ -0,0 +1,230 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Scene is the state SetScene turns the light on into, one of ColorScene, HSVScene, CTScene, FlowScene
+// and AutoDelayOffScene
+type Scene interface {
+	// Class is the class of the scene used by set_scene, e.g. "color"
+	Class() string
+
+	// params returns the class specific values sent after the class, or an error if they're out of range
+	params() ([]interface{}, error)
+
+	// apply changes the state the same way the light changes after the scene is set
+	apply(state *LightProperties, bg bool)
+}
+
+// ColorScene turns the light on with the RGB color and the brightness
+type ColorScene struct {
+	RGB    uint32 // (range 0 - 16777215)
+	Bright uint8  // (range 1 - 100)
+}
+
+func (s ColorScene) Class() string {
+	return "color"
+}
+
+func (s ColorScene) params() ([]interface{}, error) {
+	if s.RGB > 0xFFFFFF {
+		return nil, fmt.Errorf("rgb_value out of range")
+	}
+	if s.Bright < 1 || s.Bright > 100 {
+		return nil, fmt.Errorf("brightness out of range")
+	}
+	return []interface{}{s.RGB, s.Bright}, nil
+}
+
+func (s ColorScene) apply(state *LightProperties, bg bool) {
+	if bg {
+		state.Bg_On, state.Bg_RGB, state.Bg_Bright, state.Bg_Color_Mode = true, s.RGB, s.Bright, ColorModeRGB
+		return
+	}
+	state.On, state.RGB, state.Bright, state.Color_Mode = true, s.RGB, s.Bright, ColorModeRGB
+}
+
+// HSVScene turns the light on with the hue, the saturation and the brightness
+type HSVScene struct {
+	Hue    uint16 // (range 0 - 359)
+	Sat    uint8  // (range 0 - 100)
+	Bright uint8  // (range 1 - 100)
+}
+
+func (s HSVScene) Class() string {
+	return "hsv"
+}
+
+func (s HSVScene) params() ([]interface{}, error) {
+	if s.Hue > 359 {
+		return nil, fmt.Errorf("hue out of range")
+	}
+	if s.Sat > 100 {
+		return nil, fmt.Errorf("sat out of range")
+	}
+	if s.Bright < 1 || s.Bright > 100 {
+		return nil, fmt.Errorf("brightness out of range")
+	}
+	return []interface{}{s.Hue, s.Sat, s.Bright}, nil
+}
+
+func (s HSVScene) apply(state *LightProperties, bg bool) {
+	if bg {
+		state.Bg_On, state.Bg_Hue, state.Bg_Sat, state.Bg_Bright, state.Bg_Color_Mode = true, s.Hue, s.Sat, s.Bright, ColorModeHSV
+		return
+	}
+	state.On, state.Hue, state.Sat, state.Bright, state.Color_Mode = true, s.Hue, s.Sat, s.Bright, ColorModeHSV
+}
+
+// CTScene turns the light on with the color temperature and the brightness
+type CTScene struct {
+	Ct     uint16 // (range 1700 - 6500, narrower on some models, see CtRange) (unit: Kelvin)
+	Bright uint8  // (range 1 - 100)
+}
+
+func (s CTScene) Class() string {
+	return "ct"
+}
+
+func (s CTScene) params() ([]interface{}, error) {
+	if s.Ct < 1700 || s.Ct > 6500 {
+		return nil, fmt.Errorf("ct_value out of range")
+	}
+	if s.Bright < 1 || s.Bright > 100 {
+		return nil, fmt.Errorf("brightness out of range")
+	}
+	return []interface{}{s.Ct, s.Bright}, nil
+}
+
+func (s CTScene) apply(state *LightProperties, bg bool) {
+	if bg {
+		state.Bg_On, state.Bg_Ct, state.Bg_Bright, state.Bg_Color_Mode = true, s.Ct, s.Bright, ColorModeCT
+		return
+	}
+	state.On, state.Ct, state.Bright, state.Color_Mode = true, s.Ct, s.Bright, ColorModeCT
+}
+
+// FlowScene turns the light on and starts the color flow
+type FlowScene struct {
+	Flow Flow
+}
+
+func (s FlowScene) Class() string {
+	return "cf"
+}
+
+func (s FlowScene) params() ([]interface{}, error) {
+	err := s.Flow.Validate()
+	if err != nil {
+		return nil, err
+	}
+	return []interface{}{s.Flow.Count, uint8(s.Flow.Action), s.Flow.Expression()}, nil
+}
+
+func (s FlowScene) apply(state *LightProperties, bg bool) {
+	if bg {
+		state.Bg_On, state.Bg_Flowing, state.Bg_Flow_Params, state.Bg_Color_Mode = true, true, s.Flow, ColorModeFlow
+		return
+	}
+	state.On, state.Flowing, state.Flow_Params, state.Color_Mode = true, true, s.Flow, ColorModeFlow
+}
+
+// AutoDelayOffScene turns the light on with the brightness, and starts a sleep timer,
+// which turns the light off after the minutes. It's supported by the main light only.
+type AutoDelayOffScene struct {
+	Bright  uint8 // (range 1 - 100)
+	Minutes uint8 // (range 1 - 60)
+}
+
+func (s AutoDelayOffScene) Class() string {
+	return "auto_delay_off"
+}
+
+func (s AutoDelayOffScene) params() ([]interface{}, error) {
+	if s.Bright < 1 || s.Bright > 100 {
+		return nil, fmt.Errorf("brightness out of range")
+	}
+	if s.Minutes < 1 || s.Minutes > 60 {
+		return nil, fmt.Errorf("minutes out of range")
+	}
+	return []interface{}{s.Bright, s.Minutes}, nil
+}
+
+func (s AutoDelayOffScene) apply(state *LightProperties, bg bool) {
+	state.On, state.Bright, state.Delayoff = true, s.Bright, s.Minutes
+}
+
+/*
+ParseScene parses a scene written as JSON, the class selects the other fields, e.g.
+
+	{"class": "color", "rgb": 16711680, "bright": 100}
+	{"class": "hsv", "hue": 120, "sat": 100, "bright": 50}
+	{"class": "ct", "ct": 2700, "bright": 30}
+	{"class": "cf", "count": 0, "action": "recover", "steps": [{"duration": 1000, "mode": "color", "value": 255}]}
+	{"class": "auto_delay_off", "bright": 50, "minutes": 15}
+
+The steps of the color flow are the same as in ParseFlow. The scene is validated as well.
+*/
+func ParseScene(str string) (Scene, error) {
+	var fields struct {
+		Class   string  `json:"class"`
+		RGB     *uint32 `json:"rgb"`
+		Hue     *uint16 `json:"hue"`
+		Sat     *uint8  `json:"sat"`
+		Ct      *uint16 `json:"ct"`
+		Bright  *uint8  `json:"bright"`
+		Minutes *uint8  `json:"minutes"`
+	}
+	err := json.Unmarshal([]byte(str), &fields)
+	if err != nil {
+		return nil, err
+	}
+
+	// the fields of every class are required, brightness included
+	missing := func(values ...bool) error {
+		for _, present := range values {
+			if !present {
+				return fmt.Errorf("scene of class '%v' is missing a field", fields.Class)
+			}
+		}
+		return nil
+	}
+
+	var scene Scene
+	switch fields.Class {
+	case "color":
+		err = missing(fields.RGB != nil, fields.Bright != nil)
+		if err == nil {
+			scene = ColorScene{RGB: *fields.RGB, Bright: *fields.Bright}
+		}
+	case "hsv":
+		err = missing(fields.Hue != nil, fields.Sat != nil, fields.Bright != nil)
+		if err == nil {
+			scene = HSVScene{Hue: *fields.Hue, Sat: *fields.Sat, Bright: *fields.Bright}
+		}
+	case "ct":
+		err = missing(fields.Ct != nil, fields.Bright != nil)
+		if err == nil {
+			scene = CTScene{Ct: *fields.Ct, Bright: *fields.Bright}
+		}
+	case "cf":
+		var flow Flow
+		flow, err = ParseFlow(str)
+		scene = FlowScene{Flow: flow}
+	case "auto_delay_off":
+		err = missing(fields.Bright != nil, fields.Minutes != nil)
+		if err == nil {
+			scene = AutoDelayOffScene{Bright: *fields.Bright, Minutes: *fields.Minutes}
+		}
+	default:
+		return nil, fmt.Errorf("invalid scene class '%v', must be 'color', 'hsv', 'ct', 'cf' or 'auto_delay_off'", fields.Class)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = scene.params()
+	return scene, err
+}
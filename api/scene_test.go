@@ -0,0 +1,99 @@
+package api
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseScene(t *testing.T) {
+	tests := []struct {
+		str     string
+		want    Scene
+		wantErr bool
+	}{
+		{`{"class": "color", "rgb": 16711680, "bright": 50}`, ColorScene{RGB: 16711680, Bright: 50}, false},
+		{`{"class": "hsv", "hue": 240, "sat": 100, "bright": 50}`, HSVScene{Hue: 240, Sat: 100, Bright: 50}, false},
+		{`{"class": "ct", "ct": 2700, "bright": 50}`, CTScene{Ct: 2700, Bright: 50}, false},
+		{`{"class": "auto_delay_off", "bright": 50, "minutes": 5}`, AutoDelayOffScene{Bright: 50, Minutes: 5}, false},
+		{`{"class": "cf", "count": 0, "action": "stay", "steps": [{"duration": 1000, "mode": "color", "value": 255, "brightness": 10}]}`, FlowScene{Flow: Flow{
+			Action: FlowActionStay, Steps: []FlowStep{{Duration: 1000, Mode: FlowModeColor, Value: 255, Brightness: 10}},
+		}}, false},
+		{`{"class": "color", "rgb": 16711680}`, nil, true},
+		{`{"class": "color", "rgb": 16777216, "bright": 50}`, nil, true},
+		{`{"class": "color", "rgb": 16711680, "bright": 0}`, nil, true},
+		{`{"class": "hsv", "hue": 360, "sat": 100, "bright": 50}`, nil, true},
+		{`{"class": "hsv", "hue": 240, "sat": 101, "bright": 50}`, nil, true},
+		{`{"class": "ct", "ct": 1000, "bright": 50}`, nil, true},
+		{`{"class": "ct", "ct": 70000, "bright": 50}`, nil, true},
+		{`{"class": "cf", "steps": []}`, nil, true},
+		{`{"class": "auto_delay_off", "bright": 50}`, nil, true},
+		{`{"class": "music", "bright": 50}`, nil, true},
+		{`{"class": "color", "rgb": -1, "bright": 50}`, nil, true},
+		{`color,16711680,50`, nil, true},
+	}
+
+	for _, test := range tests {
+		got, err := ParseScene(test.str)
+		if (err != nil) != test.wantErr {
+			t.Errorf("ParseScene(%q) error = %v, want error %v", test.str, err, test.wantErr)
+			continue
+		}
+		if !test.wantErr && !reflect.DeepEqual(got, test.want) {
+			t.Errorf("ParseScene(%q) = %+v, want %+v", test.str, got, test.want)
+		}
+	}
+}
+
+func TestSceneApply(t *testing.T) {
+	flow := Flow{Steps: []FlowStep{{Duration: 1000, Mode: FlowModeColor, Value: 255, Brightness: 10}}}
+	tests := []struct {
+		scene    Scene
+		wantMode ColorMode
+	}{
+		{ColorScene{RGB: 255, Bright: 50}, ColorModeRGB},
+		{HSVScene{Hue: 240, Sat: 100, Bright: 50}, ColorModeHSV},
+		{CTScene{Ct: 2700, Bright: 50}, ColorModeCT},
+		{FlowScene{Flow: flow}, ColorModeFlow},
+	}
+
+	for _, test := range tests {
+		var state LightProperties
+		test.scene.apply(&state, false)
+		if !state.On || state.Color_Mode != test.wantMode {
+			t.Errorf("%T: On = %v, Color_Mode = %v, want on and %v", test.scene, state.On, state.Color_Mode, test.wantMode)
+		}
+
+		state = LightProperties{}
+		test.scene.apply(&state, true)
+		if !state.Bg_On || state.Bg_Color_Mode != test.wantMode {
+			t.Errorf("%T (bg): Bg_On = %v, Bg_Color_Mode = %v, want on and %v", test.scene, state.Bg_On, state.Bg_Color_Mode, test.wantMode)
+		}
+	}
+}
+
+func TestSetSceneCtRange(t *testing.T) {
+	tests := []struct {
+		name    string
+		set     func(l *Light) error
+		wantErr bool
+	}{
+		{"main in range", func(l *Light) error { return l.SetScene(CTScene{Ct: 2700, Bright: 50}) }, false},
+		{"main below the range of the model", func(l *Light) error { return l.SetScene(CTScene{Ct: 2000, Bright: 50}) }, true},
+		// the ambilight has the full range
+		{"bg", func(l *Light) error { return l.BgSetScene(CTScene{Ct: 2000, Bright: 50}) }, false},
+		{"bg ct", func(l *Light) error { return l.BgSetCtAbx(1700, "sudden", "0") }, false},
+		{"bg ct out of range", func(l *Light) error { return l.BgSetCtAbx(1600, "sudden", "0") }, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, light := newTestLight(t)
+			light.Model = "ceiling4"
+
+			err := test.set(light)
+			if (err != nil) != test.wantErr {
+				t.Errorf("error = %v, want error %v", err, test.wantErr)
+			}
+		})
+	}
+}
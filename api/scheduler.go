@@ -0,0 +1,203 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Priority decides the order in which the commands waiting for the quota are sent to the light
+type Priority uint8
+
+const (
+	// PriorityLow is used for polling, and it can't use the part of the quota reserved for PriorityHigh
+	PriorityLow Priority = iota
+	// PriorityHigh is used for commands changing the state of the light, e.g. the ones coming from MQTT
+	PriorityHigh
+)
+
+const (
+	// CommandQuota is the number of commands a Yeelight accepts per minute on one connection,
+	// the light stops responding for a while after it's exceeded
+	CommandQuota = 60
+	// TotalCommandQuota is the number of commands a Yeelight accepts per minute across all connections
+	TotalCommandQuota = 144
+	quotaWindow       = time.Minute
+
+	// number of commands in the quota only PriorityHigh commands may use
+	highPriorityReserve = 10
+
+	// how long a command may wait for the quota before giving up with ErrQuotaExceeded
+	maxQuotaWait = 10 * time.Second
+)
+
+/*
+scheduler makes sure the commands sent to a light don't exceed CommandQuota on the current connection, nor
+TotalCommandQuota across the connections made in the last quotaWindow, e.g. when the light is reconnected after
+a timeout. It queues the commands that have to wait for the quota, ordered by their priority.
+
+The commands sent on the music connection aren't counted, the light doesn't limit them. The commands sent to the
+light by other controllers (e.g. the Yeelight app) can't be counted either.
+*/
+type scheduler struct {
+	mutex  sync.Mutex
+	queues [PriorityHigh + 1][]*scheduledCommand
+	sent   []time.Time // when were the commands in the last quotaWindow sent on the current connection
+	total  []time.Time // when were the commands in the last quotaWindow sent on any connection
+	timer  *time.Timer
+}
+
+type scheduledCommand struct {
+	queued time.Time
+	sent   time.Time // when the command was let go, guarded by the mutex of the scheduler
+	ready  chan error
+}
+
+// wait blocks until the command may be sent, the command is counted towards the quota when this returns nil.
+// The returned time identifies the command in the quota, see release.
+func (s *scheduler) wait(ctx context.Context, priority Priority) (time.Time, error) {
+	cmd := &scheduledCommand{
+		queued: time.Now(),
+		ready:  make(chan error, 1),
+	}
+
+	s.mutex.Lock()
+	s.queues[priority] = append(s.queues[priority], cmd)
+	s.dispatchLocked()
+	s.mutex.Unlock()
+
+	select {
+	case err := <-cmd.ready:
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+
+		// both might be ready at once, the command still isn't going to be sent
+		if err == nil && ctx.Err() != nil {
+			s.releaseLocked(cmd.sent)
+			return time.Time{}, ctx.Err()
+		}
+		return cmd.sent, err
+	case <-ctx.Done():
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for k, queued := range s.queues[priority] {
+		if queued == cmd {
+			s.queues[priority] = append(s.queues[priority][:k], s.queues[priority][k+1:]...)
+			s.dispatchLocked()
+			return time.Time{}, ctx.Err()
+		}
+	}
+
+	// the command was let go (or rejected) in the meantime, it still isn't going to be sent
+	if err := <-cmd.ready; err == nil {
+		s.releaseLocked(cmd.sent)
+	}
+	return time.Time{}, ctx.Err()
+}
+
+// release gives back the part of the quota taken by the command let go at sent, e.g. because it couldn't be written
+// to the connection, so the light hasn't received it
+func (s *scheduler) release(sent time.Time) {
+	s.mutex.Lock()
+	s.releaseLocked(sent)
+	s.mutex.Unlock()
+}
+
+func (s *scheduler) releaseLocked(sent time.Time) {
+	s.sent = remove(s.sent, sent)
+	s.total = remove(s.total, sent)
+	s.dispatchLocked()
+}
+
+// reconnected starts counting the commands towards the quota of a new connection
+func (s *scheduler) reconnected() {
+	s.mutex.Lock()
+	s.sent = nil
+	s.dispatchLocked()
+	s.mutex.Unlock()
+}
+
+// dispatchLocked lets the queued commands go as long as the quota allows it,
+// and arranges for itself to be called again when the next part of the quota frees up
+func (s *scheduler) dispatchLocked() {
+	now := time.Now()
+
+	// forget the commands that aren't in the quota window anymore
+	s.sent = expire(s.sent, now)
+	s.total = expire(s.total, now)
+
+	for {
+		priority := PriorityHigh
+		if len(s.queues[priority]) == 0 {
+			priority = PriorityLow
+		}
+		if len(s.queues[priority]) == 0 {
+			return
+		}
+		cmd := s.queues[priority][0]
+
+		limit, totalLimit := CommandQuota, TotalCommandQuota
+		if priority == PriorityLow {
+			limit -= highPriorityReserve
+			totalLimit -= highPriorityReserve
+		}
+
+		if len(s.sent) < limit && len(s.total) < totalLimit {
+			s.queues[priority] = s.queues[priority][1:]
+			s.sent = append(s.sent, now)
+			s.total = append(s.total, now)
+			cmd.sent = now
+			cmd.ready <- nil
+			continue
+		}
+
+		// the oldest command that has to leave the window before this one can be sent
+		var freeAt time.Time
+		if len(s.sent) >= limit {
+			freeAt = s.sent[len(s.sent)-limit].Add(quotaWindow)
+		}
+		if len(s.total) >= totalLimit {
+			if totalFreeAt := s.total[len(s.total)-totalLimit].Add(quotaWindow); totalFreeAt.After(freeAt) {
+				freeAt = totalFreeAt
+			}
+		}
+		if freeAt.Sub(cmd.queued) > maxQuotaWait {
+			s.queues[priority] = s.queues[priority][1:]
+			cmd.ready <- fmt.Errorf("%w, next command can be sent in %v", ErrQuotaExceeded, freeAt.Sub(now).Round(time.Second))
+			continue
+		}
+
+		if s.timer == nil {
+			s.timer = time.AfterFunc(freeAt.Sub(now), func() {
+				s.mutex.Lock()
+				s.dispatchLocked()
+				s.mutex.Unlock()
+			})
+		} else {
+			s.timer.Reset(freeAt.Sub(now))
+		}
+		return
+	}
+}
+
+// expire removes the times that aren't in the quota window anymore
+func expire(sent []time.Time, now time.Time) []time.Time {
+	expired := 0
+	for expired < len(sent) && now.Sub(sent[expired]) >= quotaWindow {
+		expired++
+	}
+	return sent[expired:]
+}
+
+// remove removes one of the times equal to t, the commands let go at the same time are interchangeable
+func remove(sent []time.Time, t time.Time) []time.Time {
+	for k := len(sent) - 1; k >= 0; k-- {
+		if sent[k].Equal(t) {
+			return append(sent[:k], sent[k+1:]...)
+		}
+	}
+	return sent
+}
@@ -0,0 +1,111 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+// send lets n commands through the scheduler, and returns the error of the first one that wasn't let through
+func send(s *scheduler, priority Priority, n int) (int, error) {
+	for k := 0; k < n; k++ {
+		_, err := s.wait(context.Background(), priority)
+		if err != nil {
+			return k, err
+		}
+	}
+	return n, nil
+}
+
+func TestSchedulerQuota(t *testing.T) {
+	s := &scheduler{}
+
+	// the commands with PriorityLow leave a part of the quota to PriorityHigh
+	sent, err := send(s, PriorityLow, CommandQuota)
+	if sent != CommandQuota-highPriorityReserve || !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("%v commands with PriorityLow sent, error %v, want %v and ErrQuotaExceeded", sent, err, CommandQuota-highPriorityReserve)
+	}
+
+	sent, err = send(s, PriorityHigh, CommandQuota)
+	if sent != highPriorityReserve || !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("%v commands with PriorityHigh sent, error %v, want %v and ErrQuotaExceeded", sent, err, highPriorityReserve)
+	}
+}
+
+func TestSchedulerTotalQuota(t *testing.T) {
+	s := &scheduler{}
+
+	// a new connection has a quota of its own, but they all share the total one
+	total := 0
+	for total < TotalCommandQuota {
+		s.reconnected()
+		sent, err := send(s, PriorityHigh, CommandQuota+1)
+		total += sent
+		if total < TotalCommandQuota && sent != CommandQuota {
+			t.Fatalf("%v commands sent on a new connection, want %v", sent, CommandQuota)
+		}
+		if !errors.Is(err, ErrQuotaExceeded) {
+			t.Fatalf("error = %v, want ErrQuotaExceeded", err)
+		}
+	}
+	if total != TotalCommandQuota {
+		t.Errorf("%v commands sent in total, want %v", total, TotalCommandQuota)
+	}
+
+	s.reconnected()
+	_, err := send(s, PriorityHigh, 1)
+	if !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("error = %v, want ErrQuotaExceeded", err)
+	}
+}
+
+func TestSchedulerRelease(t *testing.T) {
+	s := &scheduler{}
+
+	sent, err := send(s, PriorityHigh, CommandQuota-1)
+	if err != nil {
+		t.Fatalf("%v commands sent, error %v", sent, err)
+	}
+
+	// the command let go, but given up on by the time it's let go, doesn't use up the quota
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for k := 0; k < 10; k++ {
+		s.wait(ctx, PriorityHigh)
+	}
+
+	slot, err := s.wait(context.Background(), PriorityHigh)
+	if err != nil {
+		t.Fatalf("wait() error = %v, want the last command of the quota let go", err)
+	}
+	s.release(slot)
+
+	sent, err = send(s, PriorityHigh, CommandQuota)
+	if sent != 1 || !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("%v commands sent after release(), error %v, want 1 and ErrQuotaExceeded", sent, err)
+	}
+}
+
+func TestSendCommandQuota(t *testing.T) {
+	bulb, light := newTestLight(t)
+
+	// the quota of the bulb is never hit, the commands over it aren't sent at all
+	for k := 0; k < CommandQuota; k++ {
+		_, err := light.SendCommand("set_bright", []interface{}{k%100 + 1, "sudden", 30}, 1)
+		if err != nil {
+			t.Fatalf("command %v failed: %v", k+1, err)
+		}
+	}
+
+	_, err := light.SendCommand("set_bright", []interface{}{42, "sudden", 30}, 1)
+	if !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("SendCommand() error = %v, want ErrQuotaExceeded", err)
+	}
+	var cmdErr *CommandError
+	if errors.As(err, &cmdErr) {
+		t.Errorf("SendCommand() error = %v, the command was sent to the bulb", err)
+	}
+	if bright := bulb.Prop("bright"); bright == "42" {
+		t.Errorf("bright of the bulb = %v, the command was sent to the bulb", bright)
+	}
+}
@@ -1,11 +1,16 @@
 package api
 
 import (
+	"bufio"
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/dsorm/yeelight2mqtt/console"
 	"net"
 	"strings"
+	"sync/atomic"
+	"syscall"
 	"time"
 )
 
@@ -13,135 +18,302 @@ import (
 var successfulSends uint64
 var unsuccessfulSends uint64
 
-type sendCommandCtx struct {
-	tries    uint64
-	maxTries uint64
-	command  string
-	l        *Light
+const (
+	// how long to wait for the response to a command
+	commandTimeout = 5 * time.Second
+
+	// how long to wait before sending a failed command again
+	retryDelay = time.Second / 2
+)
+
+var errConnectionLost = errors.New("connection to the light was lost")
+
+type command struct {
+	ID     uint64        `json:"id"`
+	Method string        `json:"method"`
+	Params []interface{} `json:"params"`
 }
 
-func (l *Light) SendCommand(command string, maxTries int) (response []byte, err error) {
-	ctx := &sendCommandCtx{
-		maxTries: uint64(maxTries),
-		command:  command,
-		l:        l,
-	}
-	return ctx.sendCommandWithCtx()
+// response is a line sent by the light, it's either a response to a command (ID and Result or Error are set),
+// or a notification (Method and Params are set)
+type response struct {
+	ID     uint64        `json:"id"`
+	Result []interface{} `json:"result"`
+	Error  *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+	Method string                 `json:"method"`
+	Params map[string]interface{} `json:"params"`
 }
 
-// this code is trash, and it probably doesn't even work properly
-func (ctx *sendCommandCtx) sendCommandWithCtx() (response []byte, err error) {
-	ctx.tries++
-	if ctx.tries >= ctx.maxTries {
-		return nil, errors.New("max tries exceeded")
-	}
+// SendCommand sends the command to the light and returns the result of it.
+// If the light can't be reached, the command is sent again, up to maxTries times in total. A command that has been
+// sent, but not responded to, isn't sent again, since the light might have executed it (e.g. toggle).
+// The command is sent with PriorityHigh, see SendCommandWithPriority.
+func (l *Light) SendCommand(method string, params []interface{}, maxTries int) (result []interface{}, err error) {
+	return l.SendCommandWithPriorityCtx(context.Background(), PriorityHigh, method, params, maxTries)
+}
 
-	ctx.l.connMutex.Lock()
+// SendCommandCtx is like SendCommand, but it gives up when ctx is done
+func (l *Light) SendCommandCtx(ctx context.Context, method string, params []interface{}, maxTries int) (result []interface{}, err error) {
+	return l.SendCommandWithPriorityCtx(ctx, PriorityHigh, method, params, maxTries)
+}
+
+// SendCommandWithPriority is like SendCommand, but the command waits for the quota of the light with the given priority.
+// If the quota doesn't free up soon enough, ErrQuotaExceeded is returned.
+func (l *Light) SendCommandWithPriority(priority Priority, method string, params []interface{}, maxTries int) (result []interface{}, err error) {
+	return l.SendCommandWithPriorityCtx(context.Background(), priority, method, params, maxTries)
+}
 
-	// a very dirty way to ratelimit the number of requests to the Yeelight, but it works :)
-	done := make(chan bool)
-	unlockMutex := func() {
-		done <- true
-		time.Sleep(time.Second / 2)
-		ctx.l.connMutex.Unlock()
+// SendCommandWithPriorityCtx is like SendCommandWithPriority, but it gives up when ctx is done.
+// The deadline of ctx applies to connecting, waiting for the quota, writing the command and waiting for the response.
+func (l *Light) SendCommandWithPriorityCtx(ctx context.Context, priority Priority, method string, params []interface{}, maxTries int) (result []interface{}, err error) {
+	// the light doesn't accept null as params
+	if params == nil {
+		params = []interface{}{}
 	}
 
-	// this function serves as a force-unlock in case everything goes wrong (it will, trust me)
-	go func() {
-		timeoutSeconds := 40
-		ticker := time.NewTicker(time.Second * time.Duration(timeoutSeconds))
+	// the music connection has no quota, and the light doesn't respond on it
+	if l.sendMusic(ctx, method, params) {
+		return []interface{}{"ok"}, nil
+	}
 
-		select {
-		case <-ticker.C:
-			console.Logf("sendCommand was stuck for %v seconds, force-unlocking mutex and closing connection\n", timeoutSeconds)
-			err = ctx.l.conn.Close()
+	for tries := 1; ; tries++ {
+		// the command counts towards the quota of the connection it's sent on, so it has to exist already
+		written := false
+		_, _, err = l.connect(ctx)
+		if err == nil {
+			var sent time.Time
+			sent, err = l.scheduler.wait(ctx, priority)
 			if err != nil {
-				console.Logf("error closing net connection: %v\n", err)
+				return nil, err
 			}
+			result, written, err = l.sendOnce(ctx, method, params)
 
-			unlockMutex()
-			return
-		case <-done:
-			ticker.Stop()
-			return
+			// the light hasn't received the command, it doesn't count towards the quota
+			if !written {
+				l.scheduler.release(sent)
+			}
 		}
+		if err == nil {
+			atomic.AddUint64(&successfulSends, 1)
+			return result, nil
+		}
+		atomic.AddUint64(&unsuccessfulSends, 1)
 
-	}()
+		// the light has responded with an error, sending the command again won't change anything,
+		// and there's no point in trying again if the caller doesn't wait for the result anymore.
+		// The light might have executed a command without a response, it would be executed twice.
+		var cmdErr *CommandError
+		if errors.As(err, &cmdErr) || ctx.Err() != nil || written {
+			return nil, err
+		}
 
-	if ctx.l.conn == nil {
-		ctx.l.conn, err = net.DialTimeout("tcp", fmt.Sprintf("%s:55443", ctx.l.Host), 5*time.Second)
-		if err != nil {
-			unlockMutex()
-			return ctx.sendCommandWithCtx()
+		if tries >= maxTries {
+			return nil, &MaxTriesError{
+				Tries: tries,
+				Err:   err,
+			}
+		}
+		console.Logf("sendCommand() to %v failed due to %v, retrying... (%v/%v)\n", l.GetHost(), err, tries, maxTries)
+
+		select {
+		case <-time.After(retryDelay):
+		case <-ctx.Done():
+			return nil, ctx.Err()
 		}
 	}
+}
 
-	// wait a while before writing command, since yeelights are a bit slow,
-	// they might write the output of last command to this command or just crap out
-	time.Sleep(time.Second / 4)
+// sendOnce sends the command and waits for the response, written tells whether the command has reached the connection
+// (the light might have executed it even if there's an error)
+func (l *Light) sendOnce(ctx context.Context, method string, params []interface{}) (result []interface{}, written bool, err error) {
+	conn, _, err := l.connect(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+	// e.g. the command has waited for the quota longer than the caller was willing to
+	if ctx.Err() != nil {
+		return nil, false, ctx.Err()
+	}
 
-	// if ctx.command is empty, just read from the connection
-	if ctx.command != "" {
-		_, err = ctx.l.conn.Write([]byte(ctx.command + "\r\n"))
-		if err != nil {
-			err = ctx.l.conn.Close()
-			if err != nil {
-				console.Logf("error closing net connection: %v\n", err)
+	id := atomic.AddUint64(&l.commandID, 1)
+	msg, err := json.Marshal(command{
+		ID:     id,
+		Method: method,
+		Params: params,
+	})
+	if err != nil {
+		return nil, false, err
+	}
+
+	respChan := make(chan response, 1)
+	l.connMutex.Lock()
+	if l.conn != conn {
+		l.connMutex.Unlock()
+		return nil, false, errConnectionLost
+	}
+	l.pending[id] = respChan
+	l.connMutex.Unlock()
+
+	forget := func() {
+		l.connMutex.Lock()
+		delete(l.pending, id)
+		l.connMutex.Unlock()
+	}
+
+	err = conn.SetWriteDeadline(writeDeadline(ctx))
+	if err == nil {
+		_, err = conn.Write(append(msg, '\r', '\n'))
+	}
+	if err != nil {
+		forget()
+		l.dropConn(conn)
+		return nil, false, err
+	}
+
+	timer := time.NewTimer(commandTimeout)
+	defer timer.Stop()
+
+	select {
+	case resp, ok := <-respChan:
+		if !ok {
+			return nil, true, errConnectionLost
+		}
+		if resp.Error != nil {
+			return nil, true, &CommandError{
+				Method:  method,
+				Code:    resp.Error.Code,
+				Message: resp.Error.Message,
 			}
-			ctx.l.conn = nil
-			unlockMutex()
-			ctx.tries++
-			return ctx.sendCommandWithCtx()
 		}
+		return resp.Result, true, nil
+	case <-timer.C:
+		// the connection is likely dead (e.g. the light lost power), the next command reconnects
+		forget()
+		l.dropConn(conn)
+		return nil, true, ErrTimeout
+	case <-ctx.Done():
+		forget()
+		return nil, true, ctx.Err()
+	}
+}
+
+// writeDeadline returns the deadline of ctx, if it's sooner than commandTimeout
+func writeDeadline(ctx context.Context) time.Time {
+	deadline := time.Now().Add(commandTimeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		return ctxDeadline
+	}
+	return deadline
+}
 
+// connect returns the current connection to the light, or creates one if there is none.
+// The returned channel is closed when the connection is lost.
+func (l *Light) connect(ctx context.Context) (net.Conn, chan struct{}, error) {
+	l.connMutex.Lock()
+	defer l.connMutex.Unlock()
+
+	if l.conn != nil {
+		return l.conn, l.connDone, nil
+	}
+
+	// the light is configured by its ID, and it hasn't advertised itself yet
+	if l.Host == "" {
+		return nil, nil, fmt.Errorf("address of light '%v' (id %v) is not known yet", l.Name, l.GetID())
+	}
+
+	// the port is only specified when it's not the default one, e.g. for the simulator
+	address := l.Host
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(address, "55443")
 	}
 
-	err = ctx.l.conn.SetDeadline(time.Now().Add(time.Second * 5))
+	dialer := net.Dialer{Timeout: 5 * time.Second}
+	conn, err := dialer.DialContext(ctx, "tcp", address)
 	if err != nil {
-		err = ctx.l.conn.Close()
-		if err != nil {
-			console.Logf("error closing net connection: %v\n", err)
+		var netErr net.Error
+		switch {
+		case errors.Is(err, syscall.ECONNREFUSED):
+			err = fmt.Errorf("%w: %v", ErrConnectionRefused, err)
+		case errors.As(err, &netErr) && netErr.Timeout():
+			err = fmt.Errorf("%w: %v", ErrTimeout, err)
 		}
-		ctx.l.conn = nil
-		unlockMutex()
-		return ctx.sendCommandWithCtx()
+		return nil, nil, err
+	}
+
+	l.conn = conn
+	l.connDone = make(chan struct{})
+	l.pending = make(map[uint64]chan response)
+	go l.readLoop(conn, l.connDone)
+
+	// the new connection has a quota of its own
+	l.scheduler.reconnected()
+
+	return l.conn, l.connDone, nil
+}
+
+// dropConn closes the connection, and lets every command waiting for a response on it know
+func (l *Light) dropConn(conn net.Conn) {
+	l.connMutex.Lock()
+	l.dropConnLocked(conn)
+	l.connMutex.Unlock()
+}
+
+func (l *Light) dropConnLocked(conn net.Conn) {
+	if l.conn != conn {
+		return
+	}
+
+	err := conn.Close()
+	if err != nil && !errors.Is(err, net.ErrClosed) {
+		console.Logf("error closing net connection: %v\n", err)
+	}
+
+	for id, respChan := range l.pending {
+		close(respChan)
+		delete(l.pending, id)
 	}
+	l.conn = nil
+}
+
+// readLoop reads everything the light sends on conn, until the connection fails
+func (l *Light) readLoop(conn net.Conn, done chan struct{}) {
+	defer close(done)
+	defer l.dropConn(conn)
 
-	resp := []byte{}
-	// read a line from response
+	reader := bufio.NewReader(conn)
 	for {
-		buf := make([]byte, 1024)
-		n, err := ctx.l.conn.Read(buf)
+		line, err := reader.ReadBytes('\n')
 		if err != nil {
-			reason := "unknown reason"
-			if strings.Contains(err.Error(), "timeout") {
-				reason = "timeout"
+			if !errors.Is(err, net.ErrClosed) {
+				console.Logf("Connection to light '%v' lost: %v\n", l.Name, err)
 			}
-			console.Logf("sendCommand() to %v failed due to %v, retrying... (%v/%v)\n", ctx.l.Host, reason, ctx.tries, ctx.maxTries)
+			return
+		}
 
-			unsuccessfulSends++
-			unlockMutex()
-			return ctx.sendCommandWithCtx()
+		var resp response
+		err = json.Unmarshal(line, &resp)
+		if err != nil {
+			console.Logf("Invalid message from light '%v': %v\n", l.Name, strings.TrimSpace(string(line)))
+			continue
 		}
-		resp = append(resp, buf[:n]...)
-		if string(resp[len(resp)-2:]) == "\r\n" {
-			successfulSends++
-			break
+
+		if resp.Method != "" {
+			l.handleNotification(resp)
+			continue
 		}
-	}
 
-	// detect if the yeelight is vomiting the (currently) unnecessary output about prop change
-	if strings.HasPrefix(string(resp), "{\"method\":\"props\",\"params\":{") {
-		// TODO make it work
-		// ctx.l.refreshMsg <- string(resp)
+		l.connMutex.Lock()
+		respChan, ok := l.pending[resp.ID]
+		delete(l.pending, resp.ID)
+		l.connMutex.Unlock()
 
-		// read again to get the requested response
-		ctx.tries -= 2
-		ctx.command = ""
-		unlockMutex()
-		return ctx.sendCommandWithCtx()
+		// nobody is waiting for this response anymore (the command has timed out)
+		if !ok {
+			continue
+		}
+		respChan <- resp
 	}
-
-	go unlockMutex()
-	return resp, err
 }
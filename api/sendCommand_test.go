@@ -0,0 +1,244 @@
+package api
+
+import (
+	"errors"
+	"github.com/dsorm/yeelight2mqtt/simulator"
+	"testing"
+	"time"
+)
+
+// newTestLight starts a simulated bulb, and returns it together with a light connected to it
+func newTestLight(t *testing.T) (*simulator.Bulb, *Light) {
+	t.Helper()
+
+	bulb := simulator.New()
+	err := bulb.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() failed: %v", err)
+	}
+	t.Cleanup(func() { bulb.Close() })
+
+	light := &Light{Host: bulb.Addr(), Name: "test"}
+	light.SetRefreshCallback(func(props []string) {})
+	return bulb, light
+}
+
+// eventually fails the test if condition doesn't become true within a second
+func eventually(t *testing.T, condition func() bool, message string) {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal(message)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSendCommand(t *testing.T) {
+	bulb, light := newTestLight(t)
+
+	result, err := light.SendCommand("set_bright", []interface{}{42, "smooth", 500}, 1)
+	if err != nil {
+		t.Fatalf("SendCommand() failed: %v", err)
+	}
+	if len(result) != 1 || result[0] != "ok" {
+		t.Errorf("SendCommand() = %v, want [ok]", result)
+	}
+	if bright := bulb.Prop("bright"); bright != "42" {
+		t.Errorf("bright of the bulb = %v, want 42", bright)
+	}
+
+	// the connection is reused
+	_, err = light.SendCommand("set_power", []interface{}{"off", "sudden", 30}, 1)
+	if err != nil {
+		t.Fatalf("SendCommand() failed: %v", err)
+	}
+	if connections := bulb.Connections(); connections != 1 {
+		t.Errorf("bulb has %v connections, want 1", connections)
+	}
+}
+
+func TestSendCommandError(t *testing.T) {
+	bulb, light := newTestLight(t)
+	bulb.Support = []string{"get_prop", "set_bright"}
+
+	_, err := light.SendCommand("set_bright", []interface{}{0, "smooth", 500}, 3)
+	var cmdErr *CommandError
+	if !errors.As(err, &cmdErr) {
+		t.Fatalf("SendCommand() error = %v, want a CommandError", err)
+	}
+	if cmdErr.Method != "set_bright" || cmdErr.Message != simulator.ErrInvalidParams.Message {
+		t.Errorf("SendCommand() error = %+v, want the invalid params of set_bright", cmdErr)
+	}
+
+	_, err = light.SendCommand("bg_set_power", []interface{}{"on", "sudden", 30}, 3)
+	if !errors.Is(err, ErrUnsupportedMethod) {
+		t.Errorf("SendCommand() error = %v, want ErrUnsupportedMethod", err)
+	}
+}
+
+func TestGetProp(t *testing.T) {
+	bulb, light := newTestLight(t)
+	bulb.Change(map[string]string{"bright": "42", "ct": "2700", "color_mode": "2", "bg_power": "on", "nl_br": ""})
+
+	err := light.GetProp()
+	if err != nil {
+		t.Fatalf("GetProp() failed: %v", err)
+	}
+
+	state := light.GetState()
+	if !state.On || state.Bright != 42 || state.Ct != 2700 || state.Color_Mode != ColorModeCT || !state.Bg_On {
+		t.Errorf("GetState() = %+v, want the props of the bulb", state)
+	}
+	if !state.Present.Has("bright") || state.Present.Has("nl_br") {
+		t.Errorf("Present = %b, want bright and not nl_br", state.Present)
+	}
+
+	values, err := light.GetProps("power", "name")
+	if err != nil {
+		t.Fatalf("GetProps() failed: %v", err)
+	}
+	if values["power"] != "on" || values["name"] != "" {
+		t.Errorf("GetProps() = %v, want power on and no name", values)
+	}
+}
+
+func TestGetPropInvalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+	}{
+		{"bright", "bright"},
+		{"ct", "70000"},
+		{"power", "maybe"},
+		{"main_power", "1"},
+		{"flowing", "on"},
+		{"active_mode", "2"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			bulb, light := newTestLight(t)
+			bulb.Change(map[string]string{test.name: test.value, "rgb": "255"})
+
+			err := light.GetProp()
+			if !errors.Is(err, ErrInvalidProp) {
+				t.Fatalf("GetProp() error = %v, want ErrInvalidProp", err)
+			}
+
+			// the rest of the state is refreshed anyway
+			if state := light.GetState(); state.RGB != 255 || state.Present.Has(test.name) {
+				t.Errorf("GetState() = %+v, want rgb 255 and no %v", state, test.name)
+			}
+		})
+	}
+}
+
+func TestNotification(t *testing.T) {
+	bulb, light := newTestLight(t)
+
+	changed := make(chan []string, 1)
+	light.SetRefreshCallback(func(props []string) { changed <- props })
+
+	err := light.GetProp()
+	if err != nil {
+		t.Fatalf("GetProp() failed: %v", err)
+	}
+
+	// e.g. using the Yeelight app
+	bulb.Change(map[string]string{"bright": "42"})
+
+	select {
+	case props := <-changed:
+		if len(props) != 1 || props[0] != "bright" {
+			t.Errorf("refresh callback got %v, want [bright]", props)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("refresh callback wasn't called")
+	}
+	if bright := light.GetState().Bright; bright != 42 {
+		t.Errorf("bright = %v, want 42", bright)
+	}
+}
+
+func TestNotificationInterleaved(t *testing.T) {
+	bulb, light := newTestLight(t)
+	bulb.SetFaults(simulator.Faults{InterleaveNotifications: true})
+
+	// the notification sent before the response isn't mistaken for it
+	err := light.SetBright(42, "smooth", "500")
+	if err != nil {
+		t.Fatalf("SetBright() failed: %v", err)
+	}
+	if bright := light.GetState().Bright; bright != 42 {
+		t.Errorf("bright = %v, want 42", bright)
+	}
+}
+
+func TestSendCommandRetry(t *testing.T) {
+	tests := []struct {
+		name     string
+		faults   simulator.Faults
+		maxTries int
+		wantErr  error
+	}{
+		// the light might have executed the command, it isn't sent again
+		{"dropped connection", simulator.Faults{DropConnections: 1}, 3, errConnectionLost},
+		{"lost response", simulator.Faults{LoseResponses: 1}, 3, errConnectionLost},
+		{"error response", simulator.Faults{Errors: map[string]simulator.Error{"set_bright": {Code: -1, Message: "general error"}}}, 3, &CommandError{}},
+		{"quota exceeded by the light", simulator.Faults{Errors: map[string]simulator.Error{"": simulator.ErrQuotaExceeded}}, 3, ErrQuotaExceeded},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			bulb, light := newTestLight(t)
+			bulb.SetFaults(test.faults)
+
+			_, err := light.SendCommand("set_bright", []interface{}{42, "smooth", 500}, test.maxTries)
+			var cmdErr *CommandError
+			switch {
+			case test.wantErr == nil && err != nil:
+				t.Fatalf("SendCommand() failed: %v", err)
+			case test.wantErr == nil:
+				if bright := bulb.Prop("bright"); bright != "42" {
+					t.Errorf("bright of the bulb = %v, want 42", bright)
+				}
+			case errors.As(test.wantErr, &cmdErr):
+				if !errors.As(err, &cmdErr) {
+					t.Errorf("SendCommand() error = %v, want a CommandError", err)
+				}
+			case !errors.Is(err, test.wantErr):
+				t.Errorf("SendCommand() error = %v, want %v", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestSendCommandRetryRefused(t *testing.T) {
+	bulb, light := newTestLight(t)
+	address := bulb.Addr()
+	bulb.Close()
+
+	// the command hasn't been sent while the light refuses the connection, so it's tried again
+	_, err := light.SendCommand("set_bright", []interface{}{42, "smooth", 500}, 2)
+	if !errors.Is(err, ErrMaxTries) || !errors.Is(err, ErrConnectionRefused) {
+		t.Fatalf("SendCommand() error = %v, want ErrMaxTries and ErrConnectionRefused", err)
+	}
+
+	go func() {
+		time.Sleep(retryDelay / 2)
+		err := bulb.Listen(address)
+		if err != nil {
+			t.Errorf("Listen() failed: %v", err)
+		}
+	}()
+	_, err = light.SendCommand("set_bright", []interface{}{42, "smooth", 500}, 3)
+	if err != nil {
+		t.Fatalf("SendCommand() failed: %v", err)
+	}
+	if bright := bulb.Prop("bright"); bright != "42" {
+		t.Errorf("bright of the bulb = %v, want 42", bright)
+	}
+}
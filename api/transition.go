@@ -0,0 +1,119 @@
+package api
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Transition is how the light changes to its new state, it's the "effect" and the "duration" taken by the setters
+type Transition struct {
+	Effect   string // "sudden" or "smooth"
+	Duration uint   // (unit: milliseconds) (30 - MaxTransitionDuration if smooth, ignored if sudden)
+}
+
+// DefaultTransition is the transition used if none is configured
+var DefaultTransition = Transition{Effect: "smooth", Duration: 500}
+
+// MaxTransitionDuration is the longest transition accepted by the setters (unit: milliseconds)
+const MaxTransitionDuration = 60000
+
+// Params returns the effect and the duration, as taken by the setters (e.g. SetBright)
+func (t Transition) Params() (string, string) {
+	if t.Effect == "sudden" {
+		// the light ignores the duration, but it has to be valid anyway
+		return t.Effect, "30"
+	}
+	return t.Effect, strconv.FormatUint(uint64(t.Duration), 10)
+}
+
+func (t Transition) Validate() error {
+	effect, duration := t.Params()
+	return validateTransition(effect, duration)
+}
+
+// String returns "sudden", or the duration of the smooth transition, e.g. "500ms" or "3s".
+// It's empty for the zero value, which stands for the transition not being set.
+func (t Transition) String() string {
+	switch t.Effect {
+	case "":
+		return ""
+	case "sudden":
+		return "sudden"
+	}
+	return (time.Duration(t.Duration) * time.Millisecond).String()
+}
+
+func (t Transition) MarshalText() ([]byte, error) {
+	return []byte(t.String()), nil
+}
+
+func (t *Transition) UnmarshalText(text []byte) error {
+	if len(text) == 0 {
+		*t = Transition{}
+		return nil
+	}
+	parsed, err := ParseTransition(string(text))
+	if err != nil {
+		return err
+	}
+	*t = parsed
+	return nil
+}
+
+/*
+ParseTransition parses a transition written as one of
+
+	sudden
+	smooth       (the duration of DefaultTransition)
+	500          (milliseconds)
+	3s           (a duration as in time.ParseDuration, e.g. 1.5s or 250ms)
+
+A duration of 0 is the same as "sudden". The transition is validated as well.
+*/
+func ParseTransition(str string) (Transition, error) {
+	str = strings.TrimSpace(str)
+	switch str {
+	case "sudden":
+		return Transition{Effect: "sudden"}, nil
+	case "smooth":
+		return DefaultTransition, nil
+	}
+
+	var duration time.Duration
+	ms, err := strconv.ParseUint(str, 10, 32)
+	if err == nil {
+		duration = time.Duration(ms) * time.Millisecond
+	} else {
+		duration, err = time.ParseDuration(str)
+		if err != nil || duration < 0 {
+			return Transition{}, fmt.Errorf("invalid transition '%v', must be 'sudden', 'smooth' or a duration", str)
+		}
+	}
+
+	if duration == 0 {
+		return Transition{Effect: "sudden"}, nil
+	}
+	t := Transition{Effect: "smooth", Duration: uint(duration.Milliseconds())}
+	return t, t.Validate()
+}
+
+// validateTransition checks the "effect" and the "duration" parameters of the setters
+func validateTransition(effect string, duration string) error {
+	if effect != "sudden" && effect != "smooth" {
+		return fmt.Errorf("effect must be 'sudden' or 'smooth'")
+	}
+
+	ms, err := strconv.ParseUint(duration, 10, 32)
+	if err != nil {
+		return fmt.Errorf("duration must be an integer")
+	}
+	if effect == "smooth" && ms < 30 {
+		return fmt.Errorf("duration must be at least 30 ms")
+	}
+	if ms > MaxTransitionDuration {
+		return fmt.Errorf("duration must be at most %v ms", MaxTransitionDuration)
+	}
+	return nil
+}
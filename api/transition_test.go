@@ -0,0 +1,38 @@
+package api
+
+import (
+	"testing"
+)
+
+func TestParseTransition(t *testing.T) {
+	tests := []struct {
+		str     string
+		want    Transition
+		wantErr bool
+	}{
+		{"sudden", Transition{Effect: "sudden"}, false},
+		{"smooth", DefaultTransition, false},
+		{"0", Transition{Effect: "sudden"}, false},
+		{"500", Transition{Effect: "smooth", Duration: 500}, false},
+		{"1.5s", Transition{Effect: "smooth", Duration: 1500}, false},
+		{"1m", Transition{Effect: "smooth", Duration: 60000}, false},
+		{"60000", Transition{Effect: "smooth", Duration: 60000}, false},
+		{"60001", Transition{}, true},
+		{"5m", Transition{}, true},
+		{"4294967295", Transition{}, true},
+		{"10", Transition{}, true},
+		{"-1s", Transition{}, true},
+		{"slow", Transition{}, true},
+	}
+
+	for _, test := range tests {
+		got, err := ParseTransition(test.str)
+		if (err != nil) != test.wantErr {
+			t.Errorf("ParseTransition(%q) error = %v, want error %v", test.str, err, test.wantErr)
+			continue
+		}
+		if !test.wantErr && got != test.want {
+			t.Errorf("ParseTransition(%q) = %+v, want %+v", test.str, got, test.want)
+		}
+	}
+}
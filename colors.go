@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"github.com/dsorm/yeelight2mqtt/api"
+	"github.com/dsorm/yeelight2mqtt/api/color"
+	"math"
+)
+
+// Yeelight properties of the color of the main light and the background light, a change of any of them changes
+// the color in every representation
+var colorProps = map[string]string{
+	"rgb":        "main",
+	"hue":        "main",
+	"sat":        "main",
+	"ct":         "main",
+	"color_mode": "main",
+	"bg_rgb":     "bg",
+	"bg_hue":     "bg",
+	"bg_sat":     "bg",
+	"bg_ct":      "bg",
+	"bg_lmode":   "bg",
+}
+
+// Homie properties of the node, which are the color of the light in different representations
+var colorProperties = []string{"rgb", "color", "hue", "sat", "color_hsv", "ct", "color_xy"}
+
+// lightColor is the color of the main light or the background light, as the light reports it
+type lightColor struct {
+	mode   api.ColorMode
+	rgb    uint32
+	hue    uint16
+	sat    uint8
+	ct     uint16
+	bright uint8
+
+	// the color temperature range of the light, see api.Light.CtRange
+	minCt, maxCt uint16
+}
+
+func mainColor(light *api.Light, state api.LightProperties) lightColor {
+	minCt, maxCt := light.CtRange()
+	return lightColor{mode: state.Color_Mode, rgb: state.RGB, hue: state.Hue, sat: state.Sat, ct: state.Ct, bright: state.Bright, minCt: minCt, maxCt: maxCt}
+}
+
+func bgColor(light *api.Light, state api.LightProperties) lightColor {
+	minCt, maxCt := light.BgCtRange()
+	return lightColor{mode: state.Bg_Color_Mode, rgb: state.Bg_RGB, hue: state.Bg_Hue, sat: state.Bg_Sat, ct: state.Bg_Ct, bright: state.Bg_Bright, minCt: minCt, maxCt: maxCt}
+}
+
+/*
+colorValues returns the values of the color properties of the node. The light only updates the properties of its
+color mode, e.g. the rgb is left as it was when the color temperature is set, so the color of the mode is converted
+to the other representations, and the stale values aren't published.
+
+It returns nil if the color mode isn't known, e.g. during a flow, in which case the properties are published as
+the light reports them.
+*/
+func colorValues(node string, c lightColor) map[string]string {
+	var rgb color.RGB
+	var hsv color.HSV
+	var xy color.XY
+	var ct float64
+
+	switch {
+	case c.mode == api.ColorModeRGB:
+		rgb = color.RGBFromInt(c.rgb)
+		hsv, xy = rgb.HSV(), rgb.XY()
+		ct = xy.Kelvin()
+	case c.mode == api.ColorModeHSV:
+		hsv = color.HSV{H: float64(c.hue), S: float64(c.sat), V: 100}
+		rgb = hsv.RGB()
+		xy = rgb.XY()
+		ct = xy.Kelvin()
+	case c.mode == api.ColorModeCT && c.ct > 0:
+		ct = float64(c.ct)
+		xy = color.KelvinToXY(ct)
+		rgb = xy.RGB()
+		hsv = rgb.HSV()
+	default:
+		return nil
+	}
+
+	// the color temperature of a saturated color is far off, it's kept within the range of the light
+	ct = math.Max(float64(c.minCt), math.Min(float64(c.maxCt), ct))
+	hue, sat := math.Round(hsv.H), math.Round(hsv.S)
+	if hue >= 360 {
+		hue = 0
+	}
+	if c.mode == api.ColorModeHSV {
+		// the conversion to RGB rounds, the hue and the saturation are known exactly
+		hue, sat = float64(c.hue), float64(c.sat)
+	}
+
+	return map[string]string{
+		node + "/rgb":       fmt.Sprintf("%v", rgb.Int()),
+		node + "/color":     api.FormatRGB(rgb.Int()),
+		node + "/hue":       fmt.Sprintf("%v", hue),
+		node + "/sat":       fmt.Sprintf("%v", sat),
+		node + "/color_hsv": fmt.Sprintf("%v,%v,%v", hue, sat, c.bright),
+		node + "/ct":        fmt.Sprintf("%.0f", ct),
+		node + "/color_xy":  fmt.Sprintf("%.4f,%.4f", xy.X, xy.Y),
+	}
+}
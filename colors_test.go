@@ -0,0 +1,33 @@
+package main
+
+import (
+	"github.com/dsorm/yeelight2mqtt/api"
+	"testing"
+)
+
+func TestColorValuesCtRange(t *testing.T) {
+	tests := []struct {
+		model  string
+		rgb    uint32
+		wantCt string
+	}{
+		{"color", 0xFF0000, "2655"},
+		{"color", 0xFFA040, "2390"},
+		// the color temperature of a color far from white is kept within the range of the light
+		{"ceiling4", 0xFF0000, "2700"},
+		{"ceiling4", 0xFFA040, "2700"},
+		{"color", 0x0000FF, "1700"},
+		{"color", 0xC0D0FF, "6500"},
+		{"ceiling4", 0xC0D0FF, "6500"},
+	}
+
+	for _, test := range tests {
+		light := &api.Light{Model: test.model}
+		state := api.LightProperties{Color_Mode: api.ColorModeRGB, RGB: test.rgb, Bright: 100}
+
+		values := colorValues("main", mainColor(light, state))
+		if ct := values["main/ct"]; ct != test.wantCt {
+			t.Errorf("ct of %06x on %v = %v, want %v", test.rgb, test.model, ct, test.wantCt)
+		}
+	}
+}
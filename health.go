@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"github.com/dsorm/yeelight2mqtt/api"
+	"github.com/dsorm/yeelight2mqtt/console"
+	"sync"
+	"time"
+)
+
+// Homie states of a device, see https://homieiot.github.io/specification/#device-lifecycle
+const (
+	stateInit         = "init"
+	stateReady        = "ready"
+	stateLost         = "lost"
+	stateAlert        = "alert"
+	stateDisconnected = "disconnected"
+)
+
+// Status of yeelight2mqtt itself, see bridgeTopic. It's offline whenever the connection to the broker is lost,
+// e.g. when yeelight2mqtt crashes, since the retained $state of the lights can't be updated then.
+const (
+	bridgeOnline  = "online"
+	bridgeOffline = "offline"
+)
+
+// a light that can't be reached is polled less and less often, but at least this often
+const maxPollBackoff = 5 * time.Minute
+
+// Reachability of a light, as seen by stateDaemon
+type lightHealth struct {
+	state     string // Homie state of the light
+	lastSeen  time.Time
+	lastError string
+	failures  int       // polls failed in a row
+	nextPoll  time.Time // the light isn't polled before this, so a dead light isn't retried on every poll
+}
+
+type healthTracker struct {
+	mutex  sync.Mutex
+	lights map[*api.Light]*lightHealth
+}
+
+// get returns the health of the light, it's in the init state until it's polled for the first time
+func (ht *healthTracker) get(light *api.Light) lightHealth {
+	ht.mutex.Lock()
+	defer ht.mutex.Unlock()
+
+	if h, ok := ht.lights[light]; ok {
+		return *h
+	}
+	return lightHealth{state: stateInit}
+}
+
+// update records the result of polling the light, returns the new health and whether the state has changed.
+// pollInterval is the base of the backoff used after failed polls.
+func (ht *healthTracker) update(light *api.Light, state string, err error, pollInterval time.Duration) (lightHealth, bool) {
+	ht.mutex.Lock()
+	defer ht.mutex.Unlock()
+
+	if ht.lights == nil {
+		ht.lights = make(map[*api.Light]*lightHealth)
+	}
+	h, ok := ht.lights[light]
+	if !ok {
+		h = &lightHealth{state: stateInit}
+		ht.lights[light] = h
+	}
+	changed := h.state != state
+	h.state = state
+
+	h.lastError = ""
+	if err != nil {
+		h.lastError = err.Error()
+	}
+
+	switch state {
+	case stateLost:
+		// the first failure doesn't delay the next poll, then the delay doubles with every failure
+		h.failures++
+		backoff := pollInterval
+		for k := 1; k < h.failures && backoff < maxPollBackoff; k++ {
+			backoff *= 2
+		}
+		if backoff > maxPollBackoff {
+			backoff = maxPollBackoff
+		}
+		h.nextPoll = time.Now().Add(backoff - pollInterval)
+	default:
+		// the light has answered, even if it's in the alert state
+		h.failures = 0
+		h.nextPoll = time.Time{}
+		h.lastSeen = time.Now()
+	}
+
+	return *h, changed
+}
+
+// Values of the properties of the status node
+func (h lightHealth) propertyValues() map[string]string {
+	lastSeen := ""
+	if !h.lastSeen.IsZero() {
+		lastSeen = h.lastSeen.Format(time.RFC3339)
+	}
+
+	return map[string]string{
+		"status/last_seen": lastSeen,
+		"status/error":     h.lastError,
+	}
+}
+
+// Record the result of polling the light, and publish the state of the light if it has changed
+func (as *AppState) setHealth(light *api.Light, state string, err error) {
+	pollInterval := time.Duration(as.LightPollingRate.Seconds) * time.Second
+	h, changed := as.health.update(light, state, err, pollInterval)
+	if changed {
+		console.Logf("Light '%v' is %v\n", light.Name, state)
+		as.publishSingleProp(light, "$state", state)
+	}
+
+	// a successful poll publishes everything, including these
+	if state != stateReady {
+		for topic, value := range h.propertyValues() {
+			as.publishSingleProp(light, topic, value)
+		}
+	}
+}
+
+// Let the controllers know the lights won't be updated anymore, used when yeelight2mqtt exits
+func (as *AppState) publishDisconnected() {
+	for _, light := range as.lights() {
+		token := as.mqttClient.Publish(fmt.Sprintf("%v/%v/$state", as.MQTTSettings.BaseTopic, light.Name), byte(as.MQTTSettings.QoS), true, stateDisconnected)
+		token.WaitTimeout(time.Second)
+	}
+	token := as.mqttClient.Publish(as.bridgeTopic(), byte(as.MQTTSettings.QoS), true, bridgeOffline)
+	token.WaitTimeout(time.Second)
+}
+
+// bridgeTopic is where the status of yeelight2mqtt is published. It starts with $, so it isn't mistaken
+// for a Homie device.
+func (as *AppState) bridgeTopic() string {
+	return as.MQTTSettings.BaseTopic + "/$yeelight2mqtt"
+}
@@ -0,0 +1,371 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/dsorm/yeelight2mqtt/api"
+	"github.com/dsorm/yeelight2mqtt/console"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"math"
+	"sort"
+	"time"
+)
+
+type HomeAssistantSettings struct {
+	// Publish the lights for the MQTT discovery of Home Assistant, in addition to Homie
+	Enabled bool
+	// Prefix of the discovery topics, "homeassistant" unless changed in Home Assistant
+	DiscoveryPrefix string
+	// The state and command topics of the lights are <BaseTopic>/<light name>/state and <BaseTopic>/<light name>/set
+	BaseTopic string
+}
+
+// Effects of the JSON commands (and offered to Home Assistant), they're color flows started on the light
+var effects = map[string]api.Flow{
+	"Disco": {Steps: []api.FlowStep{
+		{Duration: 500, Mode: api.FlowModeColor, Value: 0xFF0000, Brightness: 100},
+		{Duration: 500, Mode: api.FlowModeColor, Value: 0x00FF00, Brightness: 100},
+		{Duration: 500, Mode: api.FlowModeColor, Value: 0x0000FF, Brightness: 100},
+		{Duration: 500, Mode: api.FlowModeColor, Value: 0xFFFF00, Brightness: 100},
+	}},
+	"Police": {Steps: []api.FlowStep{
+		{Duration: 300, Mode: api.FlowModeColor, Value: 0xFF0000, Brightness: 100},
+		{Duration: 300, Mode: api.FlowModeColor, Value: 0x0000FF, Brightness: 100},
+	}},
+	"Candle": {Steps: []api.FlowStep{
+		{Duration: 800, Mode: api.FlowModeCT, Value: 2700, Brightness: 50},
+		{Duration: 800, Mode: api.FlowModeCT, Value: 2700, Brightness: 30},
+		{Duration: 1200, Mode: api.FlowModeCT, Value: 2700, Brightness: 45},
+		{Duration: 600, Mode: api.FlowModeCT, Value: 2700, Brightness: 25},
+	}},
+	"Breathe": {Steps: []api.FlowStep{
+		{Duration: 2000, Mode: api.FlowModeCT, Value: 4000, Brightness: 100},
+		{Duration: 2000, Mode: api.FlowModeCT, Value: 4000, Brightness: 1},
+	}},
+	"Sunrise": {Count: 3, Action: api.FlowActionStay, Steps: []api.FlowStep{
+		{Duration: 50, Mode: api.FlowModeColor, Value: 0xFF4D00, Brightness: 1},
+		{Duration: 360000, Mode: api.FlowModeColor, Value: 0xFFAA00, Brightness: 10},
+		{Duration: 540000, Mode: api.FlowModeCT, Value: 4000, Brightness: 100},
+	}},
+}
+
+// Payload of the JSON command and state topics, as used by the JSON schema of the MQTT light of Home Assistant
+type jsonState struct {
+	State      string   `json:"state,omitempty"`
+	Brightness *uint8   `json:"brightness,omitempty"`
+	ColorMode  string   `json:"color_mode,omitempty"`
+	Color      *haColor `json:"color,omitempty"`
+	ColorTemp  *uint    `json:"color_temp,omitempty"` // mireds
+	Ct         *uint16  `json:"ct,omitempty"`         // Kelvin, commands only
+	Effect     *string  `json:"effect,omitempty"`
+	Transition *float64 `json:"transition,omitempty"` // seconds, commands only
+}
+
+type haColor struct {
+	R *uint8   `json:"r,omitempty"`
+	G *uint8   `json:"g,omitempty"`
+	B *uint8   `json:"b,omitempty"`
+	H *float64 `json:"h,omitempty"`
+	S *float64 `json:"s,omitempty"`
+}
+
+func (as *AppState) haTopic(light *api.Light, topic string) string {
+	return fmt.Sprintf("%v/%v/%v", as.HomeAssistant.BaseTopic, light.Name, topic)
+}
+
+// haUniqueID identifies the light in Home Assistant, the device ID is preferred since the name can be changed
+func haUniqueID(light *api.Light) string {
+	if id := light.GetID(); id != "" {
+		return "yeelight_" + homieID(id)
+	}
+	return "yeelight_" + homieID(light.Name)
+}
+
+// Color modes of the light, as used by supported_color_modes
+func haColorModes(light *api.Light) []string {
+	var modes []string
+	if hasProperty(light, "main/ct") {
+		modes = append(modes, "color_temp")
+	}
+	if hasProperty(light, "main/hue") {
+		modes = append(modes, "hs")
+	}
+	if hasProperty(light, "main/rgb") {
+		modes = append(modes, "rgb")
+	}
+
+	// brightness and onoff can't be combined with the other modes
+	switch {
+	case len(modes) > 0:
+		return modes
+	case hasProperty(light, "main/bright"):
+		return []string{"brightness"}
+	}
+	return []string{"onoff"}
+}
+
+// Publish the discovery config of the light, Home Assistant adds the light when it receives it
+func (as *AppState) publishHAConfig(light *api.Light) {
+	minCt, maxCt := light.CtRange()
+	modes := haColorModes(light)
+
+	config := map[string]interface{}{
+		"name":                  nil, // the light is the only entity of the device, so it's named after the device
+		"unique_id":             haUniqueID(light),
+		"object_id":             homieID(light.Name),
+		"schema":                "json",
+		"state_topic":           as.haTopic(light, "state"),
+		"command_topic":         as.haTopic(light, "set"),
+		"supported_color_modes": modes,
+		"brightness":            modes[0] != "onoff",
+		"brightness_scale":      100,
+		"min_mireds":            1000000 / uint(maxCt),
+		"max_mireds":            1000000 / uint(minCt),
+
+		// the Homie state of the light tells whether it's reachable, but it's stale if yeelight2mqtt itself is gone
+		"availability": []map[string]string{
+			{
+				"topic":          fmt.Sprintf("%v/%v/$state", as.MQTTSettings.BaseTopic, light.Name),
+				"value_template": "{{ 'online' if value == '" + stateReady + "' else 'offline' }}",
+			},
+			{
+				"topic":                 as.bridgeTopic(),
+				"payload_available":     bridgeOnline,
+				"payload_not_available": bridgeOffline,
+			},
+		},
+		"availability_mode": "all",
+
+		"device": map[string]interface{}{
+			"identifiers":  []string{haUniqueID(light)},
+			"name":         light.Name,
+			"manufacturer": "Yeelight",
+			"model":        light.GetModel(),
+		},
+	}
+
+	if hasProperty(light, "main/flowing") {
+		names := make([]string, 0, len(effects))
+		for name := range effects {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		config["effect"] = true
+		config["effect_list"] = names
+	}
+
+	payload, err := json.Marshal(config)
+	if err != nil {
+		console.Logf("Error while creating the Home Assistant config of light '%v': %v\n", light.Name, err)
+		return
+	}
+
+	topic := fmt.Sprintf("%v/light/%v/config", as.HomeAssistant.DiscoveryPrefix, haUniqueID(light))
+	as.mqttClient.Publish(topic, byte(as.MQTTSettings.QoS), true, payload)
+}
+
+// Publish the state of the light to its Home Assistant state topic
+func (as *AppState) publishHAState(light *api.Light) {
+	if !as.HomeAssistant.Enabled {
+		return
+	}
+
+	state := light.GetState()
+	ha := jsonState{State: "OFF"}
+	if state.On {
+		ha.State = "ON"
+	}
+
+	modes := haColorModes(light)
+	if modes[0] != "onoff" && state.Present.Has("bright") {
+		bright := state.Bright
+		ha.Brightness = &bright
+	}
+
+	supports := func(mode string) bool {
+		for _, supported := range modes {
+			if supported == mode {
+				return true
+			}
+		}
+		return false
+	}
+	switch {
+	case state.Color_Mode == api.ColorModeCT && supports("color_temp") && state.Ct > 0:
+		mireds := 1000000 / uint(state.Ct)
+		ha.ColorMode, ha.ColorTemp = "color_temp", &mireds
+	case state.Color_Mode == api.ColorModeHSV && supports("hs"):
+		h, s := float64(state.Hue), float64(state.Sat)
+		ha.ColorMode, ha.Color = "hs", &haColor{H: &h, S: &s}
+	case state.Color_Mode == api.ColorModeRGB && supports("rgb"):
+		r, g, b := uint8(state.RGB>>16), uint8(state.RGB>>8), uint8(state.RGB)
+		ha.ColorMode, ha.Color = "rgb", &haColor{R: &r, G: &g, B: &b}
+	default:
+		ha.ColorMode = modes[0]
+	}
+
+	// the effect is known only if the flow is one of ours
+	if state.Flowing {
+		for name, flow := range effects {
+			if flow.String() == state.Flow_Params.String() {
+				name := name
+				ha.Effect = &name
+			}
+		}
+	}
+
+	payload, err := json.Marshal(ha)
+	if err != nil {
+		return
+	}
+	as.mqttClient.Publish(as.haTopic(light, "state"), byte(as.MQTTSettings.QoS), true, payload)
+}
+
+/*
+Carry out a JSON command, e.g. {"state": "ON", "brightness": 50, "color_temp": 370, "transition": 2}.
+
+The color is one of "color" (r, g, b or h, s, either of which can be left out), "color_temp" (mireds),
+"ct" (Kelvin) or "effect". The transition is in seconds, the transition of the light is used if it's left out.
+The light is changed by api.Light.Apply, in one step.
+*/
+func (as *AppState) handleJSONCommand(ctx context.Context, light *api.Light, payload []byte) error {
+	var cmd jsonState
+	err := json.Unmarshal(payload, &cmd)
+	if err != nil {
+		return err
+	}
+
+	change := api.Change{Transition: as.transition(ctx, light)}
+	if cmd.Transition != nil {
+		change.Transition = api.Transition{Effect: "sudden"}
+		if ms := *cmd.Transition * 1000; ms >= 30 {
+			change.Transition = api.Transition{Effect: "smooth", Duration: uint(ms)}
+		}
+	}
+
+	switch cmd.State {
+	case "ON":
+		on := true
+		change.On = &on
+	case "OFF":
+		on := false
+		change.On = &on
+	case "":
+	default:
+		return fmt.Errorf("invalid state '%v', must be 'ON' or 'OFF'", cmd.State)
+	}
+
+	if cmd.Brightness != nil {
+		bright := *cmd.Brightness
+		if bright < 1 {
+			bright = 1
+		}
+		change.Bright = &bright
+	}
+
+	if cmd.Effect != nil {
+		flow, ok := effects[*cmd.Effect]
+		if !ok {
+			return fmt.Errorf("unknown effect '%v'", *cmd.Effect)
+		}
+		change.Flow = &flow
+	}
+
+	switch {
+	case cmd.ColorTemp != nil && *cmd.ColorTemp > 0:
+		// Home Assistant doesn't know the exact range, so the color temperature is kept within it
+		minCt, maxCt := light.CtRange()
+		ct := 1000000 / *cmd.ColorTemp
+		if ct < uint(minCt) {
+			ct = uint(minCt)
+		}
+		if ct > uint(maxCt) {
+			ct = uint(maxCt)
+		}
+		ct16 := uint16(ct)
+		change.Ct = &ct16
+	case cmd.Ct != nil:
+		change.Ct = cmd.Ct
+	case cmd.Color != nil && (cmd.Color.H != nil || cmd.Color.S != nil):
+		// the light keeps the hue or the saturation missing from the command
+		if cmd.Color.H != nil {
+			if math.IsNaN(*cmd.Color.H) || *cmd.Color.H < 0 || *cmd.Color.H > 360 {
+				return fmt.Errorf("invalid hue %v, must be between 0 and 360", *cmd.Color.H)
+			}
+			hue := uint16(math.Round(*cmd.Color.H)) % 360
+			change.Hue = &hue
+		}
+		if cmd.Color.S != nil {
+			if math.IsNaN(*cmd.Color.S) || *cmd.Color.S < 0 || *cmd.Color.S > 100 {
+				return fmt.Errorf("invalid saturation %v, must be between 0 and 100", *cmd.Color.S)
+			}
+			sat := uint8(math.Round(*cmd.Color.S))
+			change.Sat = &sat
+		}
+	case cmd.Color != nil && cmd.Color.R != nil && cmd.Color.G != nil && cmd.Color.B != nil:
+		rgb := uint32(*cmd.Color.R)<<16 | uint32(*cmd.Color.G)<<8 | uint32(*cmd.Color.B)
+		change.RGB = &rgb
+	case cmd.Color != nil:
+		return fmt.Errorf("color must have either r, g, b or h, s")
+	}
+
+	return light.ApplyCtx(ctx, change)
+}
+
+// Follow the status of Home Assistant, the discovery configs of the lights are published again whenever it comes
+// online. The lights themselves are published by startHomeAssistantLight.
+func (as *AppState) startHomeAssistant() {
+	if !as.HomeAssistant.Enabled {
+		return
+	}
+	if as.HomeAssistant.DiscoveryPrefix == "" {
+		as.HomeAssistant.DiscoveryPrefix = "homeassistant"
+	}
+	if as.HomeAssistant.BaseTopic == "" {
+		as.HomeAssistant.BaseTopic = "yeelight2mqtt"
+	}
+
+	// Home Assistant forgets the lights when it restarts, unless the configs are retained by the broker
+	statusTopic := as.HomeAssistant.DiscoveryPrefix + "/status"
+	token := as.mqttClient.Subscribe(statusTopic, 1, func(client mqtt.Client, message mqtt.Message) {
+		if string(message.Payload()) != "online" {
+			return
+		}
+		for _, light := range as.lights() {
+			as.publishHAConfig(light)
+			as.publishHAState(light)
+		}
+	})
+	token.WaitTimeout(time.Second)
+	if err := token.Error(); err != nil {
+		console.Logf("Error while subscribing to topic '%v': %v\n", statusTopic, err)
+	}
+
+	console.Logln("Publishing the lights for Home Assistant!")
+}
+
+// Publish the discovery config of the light and subscribe to its command topic
+func (as *AppState) startHomeAssistantLight(light *api.Light) {
+	if !as.HomeAssistant.Enabled {
+		return
+	}
+	as.publishHAConfig(light)
+
+	callback := func(client mqtt.Client, message mqtt.Message) {
+		ctx, cancel := context.WithTimeout(context.Background(), mqttCommandTimeout)
+		defer cancel()
+
+		err := as.handleJSONCommand(ctx, light, message.Payload())
+		if err != nil {
+			console.Logf("Error while processing '%v -> %v': %v\n", message.Topic(), string(message.Payload()), err)
+		}
+		as.publishHAState(light)
+	}
+
+	token := as.mqttClient.Subscribe(as.haTopic(light, "set"), 2, callback)
+	token.WaitTimeout(time.Second)
+	if err := token.Error(); err != nil {
+		console.Logf("Error while subscribing to topic '%v': %v\n", as.haTopic(light, "set"), err)
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"github.com/dsorm/yeelight2mqtt/api"
@@ -8,14 +9,26 @@ import (
 	mqtt "github.com/eclipse/paho.mqtt.golang"
 	"gopkg.in/yaml.v2"
 	"log"
-	"math"
 	"os"
 	"os/signal"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 )
 
+const (
+	configFile = "config.yaml"
+
+	// how long to wait for a light to execute a command received over MQTT
+	mqttCommandTimeout = 10 * time.Second
+
+	// how long to wait for a light to respond when polling it, so an unreachable light doesn't hold up the others
+	pollTimeout = 5 * time.Second
+)
+
 var (
 	Version   string
 	BuildTime string
@@ -37,12 +50,39 @@ type PollingRate struct {
 	Seconds uint16
 }
 
+type DiscoverySettings struct {
+	// Adopt lights found on the local network in addition to the ones listed in Lights, both at startup and when
+	// they join the network later. The adopted lights are saved to the config.
+	Enabled bool
+
+	// How long to wait for the lights to answer the search request
+	WaitSeconds uint16
+}
+
 type AppState struct {
-	Lights           []api.Light
+	Lights           []*api.Light
 	LightPollingRate PollingRate
+	Discovery        DiscoverySettings
 	MQTTSettings     MQTTSettings
+	HomeAssistant    HomeAssistantSettings
 	mqttClient       mqtt.Client
 	Debug            bool
+
+	// guards Lights, which discovery adds the adopted lights to, see lights
+	lightsMutex sync.Mutex
+
+	// used by rediscoverLights
+	searching  int32
+	lastSearch time.Time
+
+	// lights whose sleep timer is being counted down by followDelayoff
+	countdowns sync.Map
+
+	// transitions of the lights set using the main/transition property, see transition
+	transitions sync.Map
+
+	// reachability of the lights, updated by stateDaemon
+	health healthTracker
 }
 
 func (as *AppState) publishProp(light *api.Light) {
@@ -51,153 +91,313 @@ func (as *AppState) publishProp(light *api.Light) {
 
 	// publish using mqtt
 
-	currentState := light.GetState()
-
+	minCt, maxCt := light.CtRange()
+	minBgCt, maxBgCt := light.BgCtRange()
 	retainedData := map[string]string{
 		"$homie":      "4.0",
 		"$name":       light.Name,
-		"$state":      "ready",
-		"$nodes":      "main, bg",
+		"$state":      as.health.get(light).state,
+		"$nodes":      strings.Join(nodes(light), ","),
 		"$extensions": "",
 
 		"$implementation": "dsorm/yeelight2mqtt@" + Version,
 
 		"main/$name":       light.Name + "_main",
 		"main/$type":       "Main Light",
-		"main/$properties": "on,bright,ct,rgb,hue,sat,color_mode,flowing,delayoff,flow_params,music_on,name,nl_br,moonlight_on",
+		"main/$properties": strings.Join(nodeProperties(light, "main"), ","),
+
+		"status/$name":       light.Name + "_status",
+		"status/$type":       "Connection",
+		"status/$properties": strings.Join(nodeProperties(light, "status"), ","),
+
+		"status/last_seen/name":     "Last Seen",
+		"status/last_seen/datatype": "string",
+		"status/last_seen/format":   "ISO 8601",
+		"status/last_seen/settable": "false",
+
+		"status/error/name":     "Last Error",
+		"status/error/datatype": "string",
+		"status/error/settable": "false",
 
 		"bg/$name":       light.Name + "_bg",
 		"bg/$type":       "Ambilight",
-		"bg/$properties": "bg_power,bg_flowing,bg_flow_params,bg_ct,bg_lmode,bg_bright,bg_rgb,bg_hue,bg_sat",
+		"bg/$properties": strings.Join(nodeProperties(light, "bg"), ","),
 
-		"main/on":          fmt.Sprintf("%v", currentState.On),
 		"main/on/name":     "Power",
 		"main/on/datatype": "boolean",
 		"main/on/settable": "true",
 
-		"main/bright":          fmt.Sprintf("%v", currentState.Bright),
 		"main/bright/name":     "Brightness",
 		"main/bright/datatype": "integer",
 		"main/bright/settable": "true",
 		"main/bright/unit":     "%",
 		"main/bright/format":   "1:100",
 
-		"main/ct":          fmt.Sprintf("%v", currentState.Ct),
 		"main/ct/name":     "Color Temperature",
 		"main/ct/datatype": "integer",
 		"main/ct/settable": "true",
 		"main/ct/unit":     "K",
-		"main/ct/format":   "1700:6500",
+		"main/ct/format":   fmt.Sprintf("%v:%v", minCt, maxCt),
 
-		"main/rgb":          fmt.Sprintf("%v", currentState.RGB),
 		"main/rgb/name":     "RGB color",
 		"main/rgb/datatype": "integer",
 		"main/rgb/settable": "true",
 		"main/rgb/format":   "0:16777215",
 
-		"main/hue":          fmt.Sprintf("%v", currentState.Hue),
 		"main/hue/name":     "Hue",
 		"main/hue/datatype": "integer",
 		"main/hue/settable": "true",
 		"main/hue/format":   "0:359",
 
-		"main/sat":          fmt.Sprintf("%v", currentState.Sat),
 		"main/sat/name":     "Saturation",
 		"main/sat/datatype": "integer",
 		"main/sat/settable": "true",
 		"main/sat/format":   "0:100",
 
-		"main/color_mode":          fmt.Sprintf("%v", currentState.Color_Mode),
+		"main/color/name":     "Color",
+		"main/color/datatype": "color",
+		"main/color/settable": "true",
+		"main/color/format":   "rgb",
+
+		"main/color_hsv/name":     "HSV Color",
+		"main/color_hsv/datatype": "color",
+		"main/color_hsv/settable": "true",
+		"main/color_hsv/format":   "hsv",
+
+		"main/color_xy/name":     "CIE xy Color",
+		"main/color_xy/datatype": "string",
+		"main/color_xy/settable": "false",
+
 		"main/color_mode/name":     "Color Mode",
 		"main/color_mode/datatype": "string",
 		"main/color_mode/settable": "true",
 		"main/color_mode/format":   "RGB,CT,HSV,Flow", // might not be according to Homie spec, but I believe it is useful
 
-		"main/flowing":          fmt.Sprintf("%v", currentState.Flowing),
 		"main/flowing/name":     "Flowing",
 		"main/flowing/datatype": "boolean",
 		"main/flowing/settable": "true",
 
-		"main/delayoff":          fmt.Sprintf("%v", currentState.Delayoff),
 		"main/delayoff/name":     "Delay Off",
 		"main/delayoff/datatype": "integer",
 		"main/delayoff/settable": "true",
 		"main/delayoff/unit":     "minutes",
 		"main/delayoff/format":   "0:60",
 
-		"main/flow_params":          fmt.Sprintf("%v", currentState.Flow_Params),
 		"main/flow_params/name":     "Flow Parameters",
 		"main/flow_params/datatype": "string",
 		"main/flow_params/settable": "true",
 
-		"main/music_on":          fmt.Sprintf("%v", currentState.Music_On),
+		"main/flow_count/name":     "Flow Count",
+		"main/flow_count/datatype": "integer",
+		"main/flow_count/settable": "false",
+
+		"main/flow_action/name":     "Flow Action",
+		"main/flow_action/datatype": "enum",
+		"main/flow_action/settable": "false",
+		"main/flow_action/format":   "recover,stay,off",
+
 		"main/music_on/name":     "Music On",
 		"main/music_on/datatype": "boolean",
-		"main/music_on/settable": "false",
+		"main/music_on/settable": "true",
 
-		"main/name":          fmt.Sprintf("%v", currentState.Name),
 		"main/name/name":     "Name",
 		"main/name/datatype": "string",
 		"main/name/settable": "false",
 
-		"main/nl_br":          fmt.Sprintf("%v", currentState.Nl_Br),
 		"main/nl_br/name":     "Moonlight Brightness",
 		"main/nl_br/datatype": "integer",
 		"main/nl_br/settable": "true",
 		"main/nl_br/unit":     "%",
 		"main/nl_br/format":   "1:100",
 
-		"main/moonlight_on":          fmt.Sprintf("%v", currentState.Moonlight_On),
 		"main/moonlight_on/name":     "Moonlight On",
 		"main/moonlight_on/datatype": "boolean",
 		"main/moonlight_on/settable": "true",
 
-		"bg/on":          fmt.Sprintf("%v", currentState.Bg_On),
+		"main/main_power/name":     "Main Light Power",
+		"main/main_power/datatype": "boolean",
+		"main/main_power/settable": "false",
+
+		"main/lan_ctrl/name":     "LAN Control",
+		"main/lan_ctrl/datatype": "boolean",
+		"main/lan_ctrl/settable": "false",
+
+		"main/save_state/name":     "Restore State on Power On",
+		"main/save_state/datatype": "boolean",
+		"main/save_state/settable": "false",
+
+		"main/init_power_on/name":     "Power On Behavior",
+		"main/init_power_on/datatype": "integer",
+		"main/init_power_on/settable": "false",
+		"main/init_power_on/format":   "1:2",
+
+		"main/transition/name":     "Transition",
+		"main/transition/datatype": "integer",
+		"main/transition/settable": "true",
+		"main/transition/unit":     "ms",
+		"main/transition/format":   fmt.Sprintf("0:%v", api.MaxTransitionDuration), // 0 means sudden
+
+		"main/scene/name":     "Scene",
+		"main/scene/datatype": "string",
+		"main/scene/settable": "true",
+		"main/scene/retained": "false",
+
+		"main/json/name":     "JSON Command",
+		"main/json/datatype": "string",
+		"main/json/settable": "true",
+		"main/json/retained": "false",
+
+		"main/toggle/name":     "Toggle",
+		"main/toggle/datatype": "boolean",
+		"main/toggle/settable": "true",
+		"main/toggle/retained": "false",
+
+		"main/dev_toggle/name":     "Toggle Main and Background",
+		"main/dev_toggle/datatype": "boolean",
+		"main/dev_toggle/settable": "true",
+		"main/dev_toggle/retained": "false",
+
+		"main/default/name":     "Save as Default",
+		"main/default/datatype": "boolean",
+		"main/default/settable": "true",
+		"main/default/retained": "false",
+
+		// a signed percentage, optionally followed by the duration in ms, e.g. "-10" or "+20,1000"
+		"main/bright_adjust/name":     "Adjust Brightness",
+		"main/bright_adjust/datatype": "string",
+		"main/bright_adjust/settable": "true",
+		"main/bright_adjust/retained": "false",
+
+		"main/ct_adjust/name":     "Adjust Color Temperature",
+		"main/ct_adjust/datatype": "string",
+		"main/ct_adjust/settable": "true",
+		"main/ct_adjust/retained": "false",
+
+		"main/color_adjust/name":     "Adjust Color",
+		"main/color_adjust/datatype": "string",
+		"main/color_adjust/settable": "true",
+		"main/color_adjust/retained": "false",
+
 		"bg/on/name":     "Power",
 		"bg/on/datatype": "boolean",
 		"bg/on/settable": "true",
 
-		"bg/flowing":          fmt.Sprintf("%v", currentState.Bg_Flowing),
 		"bg/flowing/name":     "Flowing",
 		"bg/flowing/datatype": "boolean",
 		"bg/flowing/settable": "true",
 
-		"bg/flow_params":          fmt.Sprintf("%v", currentState.Bg_Flow_Params),
 		"bg/flow_params/name":     "Flow Parameters",
 		"bg/flow_params/datatype": "string",
 		"bg/flow_params/settable": "true",
 
-		"bg/ct":          fmt.Sprintf("%v", currentState.Bg_Ct),
+		"bg/flow_count/name":     "Flow Count",
+		"bg/flow_count/datatype": "integer",
+		"bg/flow_count/settable": "false",
+
+		"bg/flow_action/name":     "Flow Action",
+		"bg/flow_action/datatype": "enum",
+		"bg/flow_action/settable": "false",
+		"bg/flow_action/format":   "recover,stay,off",
+
 		"bg/ct/name":     "Color Temperature",
 		"bg/ct/datatype": "integer",
 		"bg/ct/settable": "true",
 		"bg/ct/unit":     "K",
-		"bg/ct/format":   "1700:6500",
+		"bg/ct/format":   fmt.Sprintf("%v:%v", minBgCt, maxBgCt),
 
-		"bg/color_mode":          fmt.Sprintf("%v", currentState.Bg_Color_Mode),
 		"bg/color_mode/name":     "Color Mode",
 		"bg/color_mode/datatype": "string",
 		"bg/color_mode/settable": "true",
 		"bg/color_mode/format":   "RGB,CT,HSV,Flow",
 
-		"bg/bright":          fmt.Sprintf("%v", currentState.Bg_Bright),
 		"bg/bright/name":     "Brightness",
 		"bg/bright/datatype": "integer",
 		"bg/bright/settable": "true",
 		"bg/bright/unit":     "%",
 		"bg/bright/format":   "1:100",
 
-		"bg/rgb":          fmt.Sprintf("%v", currentState.Bg_RGB),
 		"bg/rgb/name":     "RGB color",
 		"bg/rgb/datatype": "integer",
 		"bg/rgb/settable": "true",
 		"bg/rgb/format":   "0:16777215",
 
-		"bg/hue":          fmt.Sprintf("%v", currentState.Bg_Hue),
 		"bg/hue/name":     "Hue",
 		"bg/hue/datatype": "integer",
 		"bg/hue/settable": "true",
 		"bg/hue/format":   "0:359",
+
+		"bg/sat/name":     "Saturation",
+		"bg/sat/datatype": "integer",
+		"bg/sat/settable": "true",
+		"bg/sat/format":   "0:100",
+
+		"bg/color/name":     "Color",
+		"bg/color/datatype": "color",
+		"bg/color/settable": "true",
+		"bg/color/format":   "rgb",
+
+		"bg/color_hsv/name":     "HSV Color",
+		"bg/color_hsv/datatype": "color",
+		"bg/color_hsv/settable": "true",
+		"bg/color_hsv/format":   "hsv",
+
+		"bg/color_xy/name":     "CIE xy Color",
+		"bg/color_xy/datatype": "string",
+		"bg/color_xy/settable": "false",
+
+		"bg/proact/name":     "Follow Main Light",
+		"bg/proact/datatype": "boolean",
+		"bg/proact/settable": "false",
+
+		"bg/scene/name":     "Scene",
+		"bg/scene/datatype": "string",
+		"bg/scene/settable": "true",
+		"bg/scene/retained": "false",
+
+		"bg/toggle/name":     "Toggle",
+		"bg/toggle/datatype": "boolean",
+		"bg/toggle/settable": "true",
+		"bg/toggle/retained": "false",
+
+		"bg/default/name":     "Save as Default",
+		"bg/default/datatype": "boolean",
+		"bg/default/settable": "true",
+		"bg/default/retained": "false",
+
+		"bg/bright_adjust/name":     "Adjust Brightness",
+		"bg/bright_adjust/datatype": "string",
+		"bg/bright_adjust/settable": "true",
+		"bg/bright_adjust/retained": "false",
+
+		"bg/ct_adjust/name":     "Adjust Color Temperature",
+		"bg/ct_adjust/datatype": "string",
+		"bg/ct_adjust/settable": "true",
+		"bg/ct_adjust/retained": "false",
+
+		"bg/color_adjust/name":     "Adjust Color",
+		"bg/color_adjust/datatype": "string",
+		"bg/color_adjust/settable": "true",
+		"bg/color_adjust/retained": "false",
+	}
+
+	for topic, value := range propertyValues(light, light.GetState()) {
+		retainedData[topic] = value
+	}
+	for topic, value := range as.health.get(light).propertyValues() {
+		retainedData[topic] = value
+	}
+	retainedData["main/transition"] = transitionValue(as.lightTransition(light))
+
+	// leave out the properties (and nodes) the light doesn't have
+	for topic := range retainedData {
+		node, property, _ := strings.Cut(topic, "/")
+		if property == "" || strings.HasPrefix(node, "$") {
+			continue
+		}
+		property, _, _ = strings.Cut(property, "/")
+		if (node == "bg" && !light.HasBackground()) || (!strings.HasPrefix(property, "$") && !hasProperty(light, node+"/"+property)) {
+			delete(retainedData, topic)
+		}
 	}
 
 	// data := map[string]string{
@@ -211,6 +411,261 @@ func (as *AppState) publishProp(light *api.Light) {
 	//	as.mqttClient.Publish(baseTopic+topic, 0, false, value)
 	// }
 
+	as.publishHAState(light)
+}
+
+// Homie properties of the nodes, the ones a light doesn't have are left out by nodeProperties
+var homieProperties = map[string][]string{
+	"main":   {"on", "bright", "ct", "rgb", "hue", "sat", "color", "color_hsv", "color_xy", "color_mode", "flowing", "delayoff", "flow_params", "flow_count", "flow_action", "music_on", "name", "nl_br", "moonlight_on", "main_power", "lan_ctrl", "save_state", "init_power_on", "transition", "scene", "json", "toggle", "dev_toggle", "default", "bright_adjust", "ct_adjust", "color_adjust"},
+	"status": {"last_seen", "error"},
+	"bg":     {"on", "flowing", "flow_params", "flow_count", "flow_action", "ct", "color_mode", "bright", "rgb", "hue", "sat", "color", "color_hsv", "color_xy", "proact", "scene", "toggle", "default", "bright_adjust", "ct_adjust", "color_adjust"},
+}
+
+// Methods a light has to support (any of them) to have the Homie property.
+// The properties not listed here are there for every light.
+var propertyMethods = map[string][]string{
+	"main/bright":        {"set_bright"},
+	"main/ct":            {"set_ct_abx", "set_rgb"},
+	"main/rgb":           {"set_rgb"},
+	"main/hue":           {"set_hsv"},
+	"main/sat":           {"set_hsv"},
+	"main/color":         {"set_rgb"},
+	"main/color_hsv":     {"set_hsv"},
+	"main/color_xy":      {"set_rgb", "set_hsv", "set_ct_abx"},
+	"main/color_mode":    {"set_rgb", "set_hsv"},
+	"main/flowing":       {"start_cf"},
+	"main/flow_params":   {"start_cf"},
+	"main/flow_count":    {"start_cf"},
+	"main/flow_action":   {"start_cf"},
+	"main/delayoff":      {"cron_add"},
+	"main/music_on":      {"set_music"},
+	"main/main_power":    {"bg_set_power"},
+	"main/scene":         {"set_scene"},
+	"main/toggle":        {"toggle"},
+	"main/dev_toggle":    {"dev_toggle"},
+	"main/default":       {"set_default"},
+	"main/bright_adjust": {"adjust_bright"},
+	"main/ct_adjust":     {"adjust_ct"},
+	"main/color_adjust":  {"adjust_color"},
+	"bg/on":              {"bg_set_power"},
+	"bg/flowing":         {"bg_start_cf"},
+	"bg/flow_params":     {"bg_start_cf"},
+	"bg/flow_count":      {"bg_start_cf"},
+	"bg/flow_action":     {"bg_start_cf"},
+	"bg/ct":              {"bg_set_ct_abx", "bg_set_rgb"},
+	"bg/color_mode":      {"bg_set_rgb", "bg_set_hsv"},
+	"bg/bright":          {"bg_set_bright"},
+	"bg/rgb":             {"bg_set_rgb"},
+	"bg/hue":             {"bg_set_hsv"},
+	"bg/sat":             {"bg_set_hsv"},
+	"bg/color":           {"bg_set_rgb"},
+	"bg/color_hsv":       {"bg_set_hsv"},
+	"bg/color_xy":        {"bg_set_rgb", "bg_set_hsv", "bg_set_ct_abx"},
+	"bg/proact":          {"bg_set_power"},
+	"bg/scene":           {"bg_set_scene"},
+	"bg/toggle":          {"bg_toggle"},
+	"bg/default":         {"bg_set_default"},
+	"bg/bright_adjust":   {"bg_adjust_bright"},
+	"bg/ct_adjust":       {"bg_adjust_ct"},
+	"bg/color_adjust":    {"bg_adjust_color"},
+}
+
+// hasProperty reports whether the light has the Homie property (e.g. main/rgb), according to its model and
+// the methods it supports
+func hasProperty(light *api.Light, property string) bool {
+	if strings.HasPrefix(property, "bg/") && !light.HasBackground() {
+		return false
+	}
+
+	switch property {
+	case "main/nl_br", "main/moonlight_on":
+		return light.HasMoonlight()
+	}
+
+	methods, ok := propertyMethods[property]
+	if !ok {
+		return true
+	}
+	for _, method := range methods {
+		if light.Supports(method) {
+			return true
+		}
+	}
+	return false
+}
+
+// Homie nodes of the light, the background light is there only if the light has an ambilight
+func nodes(light *api.Light) []string {
+	if light.HasBackground() {
+		return []string{"main", "bg", "status"}
+	}
+	return []string{"main", "status"}
+}
+
+// Homie properties of the node the light has
+func nodeProperties(light *api.Light, node string) []string {
+	var properties []string
+	for _, property := range homieProperties[node] {
+		if hasProperty(light, node+"/"+property) {
+			properties = append(properties, property)
+		}
+	}
+	return properties
+}
+
+// Values of the Homie properties, keyed by their topic. The properties the light doesn't have are left out.
+func propertyValues(light *api.Light, currentState api.LightProperties) map[string]string {
+	values := map[string]string{
+		"main/on":            fmt.Sprintf("%v", currentState.On),
+		"main/bright":        fmt.Sprintf("%v", currentState.Bright),
+		"main/ct":            fmt.Sprintf("%v", currentState.Ct),
+		"main/rgb":           fmt.Sprintf("%v", currentState.RGB),
+		"main/hue":           fmt.Sprintf("%v", currentState.Hue),
+		"main/sat":           fmt.Sprintf("%v", currentState.Sat),
+		"main/color":         api.FormatRGB(currentState.RGB),
+		"main/color_hsv":     fmt.Sprintf("%v,%v,%v", currentState.Hue, currentState.Sat, currentState.Bright),
+		"main/color_mode":    fmt.Sprintf("%v", currentState.Color_Mode),
+		"main/flowing":       fmt.Sprintf("%v", currentState.Flowing),
+		"main/delayoff":      fmt.Sprintf("%v", currentState.Delayoff),
+		"main/flow_params":   fmt.Sprintf("%v", currentState.Flow_Params),
+		"main/flow_count":    fmt.Sprintf("%v", currentState.Flow_Params.Count),
+		"main/flow_action":   fmt.Sprintf("%v", currentState.Flow_Params.Action),
+		"main/music_on":      fmt.Sprintf("%v", currentState.Music_On),
+		"main/name":          fmt.Sprintf("%v", currentState.Name),
+		"main/nl_br":         fmt.Sprintf("%v", currentState.Nl_Br),
+		"main/moonlight_on":  fmt.Sprintf("%v", currentState.Moonlight_On),
+		"bg/on":              fmt.Sprintf("%v", currentState.Bg_On),
+		"bg/flowing":         fmt.Sprintf("%v", currentState.Bg_Flowing),
+		"bg/flow_params":     fmt.Sprintf("%v", currentState.Bg_Flow_Params),
+		"bg/flow_count":      fmt.Sprintf("%v", currentState.Bg_Flow_Params.Count),
+		"bg/flow_action":     fmt.Sprintf("%v", currentState.Bg_Flow_Params.Action),
+		"bg/ct":              fmt.Sprintf("%v", currentState.Bg_Ct),
+		"bg/color_mode":      fmt.Sprintf("%v", currentState.Bg_Color_Mode),
+		"bg/bright":          fmt.Sprintf("%v", currentState.Bg_Bright),
+		"bg/rgb":             fmt.Sprintf("%v", currentState.Bg_RGB),
+		"bg/hue":             fmt.Sprintf("%v", currentState.Bg_Hue),
+		"bg/sat":             fmt.Sprintf("%v", currentState.Bg_Sat),
+		"bg/color":           api.FormatRGB(currentState.Bg_RGB),
+		"bg/color_hsv":       fmt.Sprintf("%v,%v,%v", currentState.Bg_Hue, currentState.Bg_Sat, currentState.Bg_Bright),
+		"bg/proact":          fmt.Sprintf("%v", currentState.Bg_Proact),
+		"main/main_power":    fmt.Sprintf("%v", currentState.Main_Power),
+		"main/lan_ctrl":      fmt.Sprintf("%v", currentState.Lan_Ctrl),
+		"main/save_state":    fmt.Sprintf("%v", currentState.Save_State),
+		"main/init_power_on": fmt.Sprintf("%v", currentState.Init_Power_On),
+	}
+
+	// e.g. a mono bulb reports an empty rgb, which would be published as 0 otherwise
+	for prop, topics := range propTopics {
+		if !currentState.Present.Has(prop) {
+			for _, topic := range topics {
+				delete(values, topic)
+			}
+		}
+	}
+
+	// the color of the color mode replaces the stale values of the other modes, the color temperature and
+	// the xy color are there even if the light doesn't report them, e.g. when the color temperature is emulated
+	for node, c := range map[string]lightColor{"main": mainColor(light, currentState), "bg": bgColor(light, currentState)} {
+		for topic, value := range colorValues(node, c) {
+			if _, ok := values[topic]; ok || topic == node+"/ct" || topic == node+"/color_xy" {
+				values[topic] = value
+			}
+		}
+	}
+
+	return values
+}
+
+// Homie topics of the Yeelight properties (some properties are published as multiple topics), used to publish the changes the lights notify about
+var propTopics = map[string][]string{
+	"power":          {"main/on"},
+	"bright":         {"main/bright", "main/color_hsv"},
+	"ct":             {"main/ct"},
+	"rgb":            {"main/rgb", "main/color"},
+	"hue":            {"main/hue", "main/color_hsv"},
+	"sat":            {"main/sat", "main/color_hsv"},
+	"color_mode":     {"main/color_mode"},
+	"flowing":        {"main/flowing"},
+	"delayoff":       {"main/delayoff"},
+	"flow_params":    {"main/flow_params", "main/flow_count", "main/flow_action"},
+	"music_on":       {"main/music_on"},
+	"name":           {"main/name"},
+	"nl_br":          {"main/nl_br"},
+	"active_mode":    {"main/moonlight_on"},
+	"bg_power":       {"bg/on"},
+	"bg_flowing":     {"bg/flowing"},
+	"bg_flow_params": {"bg/flow_params", "bg/flow_count", "bg/flow_action"},
+	"bg_ct":          {"bg/ct"},
+	"bg_lmode":       {"bg/color_mode"},
+	"bg_bright":      {"bg/bright", "bg/color_hsv"},
+	"bg_rgb":         {"bg/rgb", "bg/color"},
+	"bg_hue":         {"bg/hue", "bg/color_hsv"},
+	"bg_sat":         {"bg/sat", "bg/color_hsv"},
+	"bg_proact":      {"bg/proact"},
+	"main_power":     {"main/main_power"},
+	"lan_ctrl":       {"main/lan_ctrl"},
+	"save_state":     {"main/save_state"},
+	"init_power_on":  {"main/init_power_on"},
+}
+
+// Publish the properties the light has notified about
+func (as *AppState) publishChangedProps(light *api.Light, props []string) {
+	values := propertyValues(light, light.GetState())
+	published := make(map[string]bool)
+	for _, prop := range props {
+		topics := append([]string{}, propTopics[prop]...)
+		// the color is published in every representation, see colorValues
+		if node, ok := colorProps[prop]; ok {
+			for _, property := range colorProperties {
+				topics = append(topics, node+"/"+property)
+			}
+		}
+
+		for _, topic := range topics {
+			if value, ok := values[topic]; ok && !published[topic] && hasProperty(light, topic) {
+				published[topic] = true
+				as.publishSingleProp(light, topic, value)
+			}
+		}
+
+		// the timer might have been set using the Yeelight app
+		if prop == "delayoff" {
+			as.followDelayoff(light)
+		}
+	}
+	as.publishHAState(light)
+}
+
+// followDelayoff publishes the minutes left until the light turns off every minute, until the timer runs out.
+// The lights don't notify about the timer counting down.
+func (as *AppState) followDelayoff(light *api.Light) {
+	if light.GetState().Delayoff == 0 {
+		return
+	}
+	if _, following := as.countdowns.LoadOrStore(light, true); following {
+		return
+	}
+
+	go func() {
+		defer as.countdowns.Delete(light)
+
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+		for range ticker.C {
+			ctx, cancel := context.WithTimeout(context.Background(), pollTimeout)
+			minutes, err := light.CronGetCtx(ctx, "0")
+			cancel()
+			if err != nil {
+				console.Logf("Error while refreshing the sleep timer of light '%v': %v\n", light.Name, err)
+				return
+			}
+
+			as.publishSingleProp(light, "main/delayoff", fmt.Sprintf("%v", minutes))
+			if minutes == 0 {
+				return
+			}
+		}
+	}()
 }
 
 func (as *AppState) publishSingleProp(light *api.Light, topic string, payload interface{}) {
@@ -220,8 +675,102 @@ func (as *AppState) publishSingleProp(light *api.Light, topic string, payload in
 }
 
 func (as *AppState) subProp(l *api.Light) {
-	topicsToSubscribe := map[string]func(client mqtt.Client, message mqtt.Message){
-		"main/on/set": func(client mqtt.Client, message mqtt.Message) {
+	topicsToSubscribe := map[string]func(ctx context.Context, message mqtt.Message){
+		"main/scene/set": func(ctx context.Context, message mqtt.Message) {
+			// verify payload
+			scene, err := api.ParseScene(string(message.Payload()))
+			if err != nil {
+				console.Logf("'%v -> %v': Error while parsing the scene: %v\n", message.Topic(), string(message.Payload()), err)
+				return
+			}
+
+			// change stuff
+			err = l.SetSceneCtx(ctx, scene)
+			if err != nil {
+				console.Logf("Error while processing '%v -> %v': %v\n", message.Topic(), string(message.Payload()), err)
+				return
+			}
+
+			// update state, the scene might have changed any of these
+			as.publishChangedProps(l, []string{"power", "bright", "ct", "rgb", "hue", "sat", "color_mode", "flowing", "flow_params", "delayoff"})
+		},
+
+		"main/transition/set": func(ctx context.Context, message mqtt.Message) {
+			// verify payload, milliseconds are expected, but "sudden" or e.g. "3s" work as well
+			transition, err := api.ParseTransition(string(message.Payload()))
+			if err != nil {
+				console.Logf("'%v -> %v': Error while parsing the transition: %v\n", message.Topic(), string(message.Payload()), err)
+				return
+			}
+
+			// change stuff, it isn't saved to the config
+			as.transitions.Store(l, transition)
+
+			// update state
+			as.publishSingleProp(l, "main/transition", transitionValue(transition))
+		},
+
+		"main/json/set": func(ctx context.Context, message mqtt.Message) {
+			// verify payload and change stuff, the payload is parsed by as.handleJSONCommand
+			err := as.handleJSONCommand(ctx, l, message.Payload())
+			if err != nil {
+				console.Logf("Error while processing '%v -> %v': %v\n", message.Topic(), string(message.Payload()), err)
+				return
+			}
+
+			// update state
+			as.publishChangedProps(l, []string{"power", "bright", "ct", "rgb", "hue", "sat", "color_mode", "flowing", "flow_params"})
+		},
+
+		"main/toggle/set": func(ctx context.Context, message mqtt.Message) {
+			// verify payload, only "true" does something
+			if string(message.Payload()) != "true" {
+				return
+			}
+
+			// change stuff
+			err := l.ToggleCtx(ctx)
+			if err != nil {
+				console.Logf("Error while processing '%v -> %v': %v\n", message.Topic(), string(message.Payload()), err)
+				return
+			}
+
+			// update state
+			as.publishChangedProps(l, []string{"power"})
+		},
+
+		"main/dev_toggle/set": func(ctx context.Context, message mqtt.Message) {
+			// verify payload, only "true" does something
+			if string(message.Payload()) != "true" {
+				return
+			}
+
+			// change stuff
+			err := l.DevToggleCtx(ctx)
+			if err != nil {
+				console.Logf("Error while processing '%v -> %v': %v\n", message.Topic(), string(message.Payload()), err)
+				return
+			}
+
+			// update state
+			as.publishChangedProps(l, []string{"power", "bg_power"})
+		},
+
+		"main/default/set": func(ctx context.Context, message mqtt.Message) {
+			// verify payload, only "true" does something
+			if string(message.Payload()) != "true" {
+				return
+			}
+
+			// change stuff, the light remembers its current state after a power loss
+			err := l.SetDefaultCtx(ctx)
+			if err != nil {
+				console.Logf("Error while processing '%v -> %v': %v\n", message.Topic(), string(message.Payload()), err)
+				return
+			}
+		},
+
+		"main/on/set": func(ctx context.Context, message mqtt.Message) {
 			// yeelight2mqtt internally uses bool as a bool (makes sense)
 			// but yeelights use string with 'on' or 'off' as a bool
 			// and the Homie specification requires a string with 'true' or 'false'
@@ -243,7 +792,8 @@ func (as *AppState) subProp(l *api.Light) {
 			}
 
 			// change stuff
-			err := l.SetPower(yeelightBool, "smooth", "500", "")
+			effect, duration := as.transition(ctx, l).Params()
+			err := l.SetPowerCtx(ctx, yeelightBool, effect, duration, "")
 			if err != nil {
 				console.Logf("Error while processing '%v -> %v': %v\n", message.Topic(), string(message.Payload()), err)
 				return
@@ -256,107 +806,481 @@ func (as *AppState) subProp(l *api.Light) {
 			as.publishSingleProp(l, "main/on", fmt.Sprintf("%v", state.On))
 		},
 
-		"main/bright/set": func(client mqtt.Client, message mqtt.Message) {
+		"main/bright/set": func(ctx context.Context, message mqtt.Message) {
 			// verify payload
-			brightness, err := strconv.Atoi(string(message.Payload()))
+			brightness, err := strconv.ParseUint(string(message.Payload()), 10, 8)
+			if err != nil {
+				console.Logf("Error while processing '%v -> %v': %v\n", message.Topic(), string(message.Payload()), err)
+				return
+			}
+
+			// change stuff
+			effect, duration := as.transition(ctx, l).Params()
+			err = l.SetBrightCtx(ctx, uint8(brightness), effect, duration)
 			if err != nil {
 				console.Logf("Error while processing '%v -> %v': %v\n", message.Topic(), string(message.Payload()), err)
 				return
 			}
-			if brightness > math.MaxUint8 {
-				console.Logf("Error while processing '%v -> %v': brightness too high\n", message.Topic(), string(message.Payload()))
+
+			// update state
+			as.publishSingleProp(l, "main/bright", fmt.Sprintf("%v", l.GetState().Bright))
+		},
+
+		"main/ct/set": func(ctx context.Context, message mqtt.Message) {
+			// verify payload
+			ct, err := strconv.ParseUint(string(message.Payload()), 10, 16)
+			if err != nil {
+				console.Logf("'%v -> %v': Error while converting to int: %v\n", message.Topic(), string(message.Payload()), err)
 				return
 			}
 
 			// change stuff
-			err = l.SetBright(uint8(brightness), "smooth", "500")
+			effect, duration := as.transition(ctx, l).Params()
+			err = l.SetCtAbxCtx(ctx, uint(ct), effect, duration)
 			if err != nil {
 				console.Logf("Error while processing '%v -> %v': %v\n", message.Topic(), string(message.Payload()), err)
 				return
 			}
 
 			// update state
-			as.publishSingleProp(l, "main/bright", fmt.Sprintf("%v", l.GetState().Bright))
+			as.publishChangedProps(l, []string{"ct", "color_mode"})
+		},
+
+		"main/rgb/set": func(ctx context.Context, message mqtt.Message) {
+			// verify payload
+			rgb, err := api.ParseRGB(string(message.Payload()))
+			if err != nil {
+				console.Logf("'%v -> %v': Error while parsing the color: %v\n", message.Topic(), string(message.Payload()), err)
+				return
+			}
+
+			// change stuff
+			effect, duration := as.transition(ctx, l).Params()
+			err = l.SetRGBCtx(ctx, rgb, effect, duration)
+			if err != nil {
+				console.Logf("Error while processing '%v -> %v': %v\n", message.Topic(), string(message.Payload()), err)
+				return
+			}
+
+			// update state
+			as.publishChangedProps(l, []string{"rgb", "color_mode"})
+		},
+
+		"main/hue/set": func(ctx context.Context, message mqtt.Message) {
+			// verify payload
+			hue, err := strconv.ParseUint(string(message.Payload()), 10, 16)
+			if err != nil {
+				console.Logf("'%v -> %v': Error while converting to int: %v\n", message.Topic(), string(message.Payload()), err)
+				return
+			}
+
+			// change stuff
+			effect, duration := as.transition(ctx, l).Params()
+			err = l.SetHSVCtx(ctx, uint16(hue), l.GetState().Sat, effect, duration)
+			if err != nil {
+				console.Logf("Error while processing '%v -> %v': %v\n", message.Topic(), string(message.Payload()), err)
+				return
+			}
+
+			// update state
+			as.publishChangedProps(l, []string{"hue", "color_mode"})
+		},
+
+		"main/color/set": func(ctx context.Context, message mqtt.Message) {
+			// verify payload, r,g,b is expected, but #RRGGBB or a color name work as well
+			rgb, err := api.ParseRGB(string(message.Payload()))
+			if err != nil {
+				console.Logf("'%v -> %v': Error while parsing the color: %v\n", message.Topic(), string(message.Payload()), err)
+				return
+			}
+
+			// change stuff
+			effect, duration := as.transition(ctx, l).Params()
+			err = l.SetRGBCtx(ctx, rgb, effect, duration)
+			if err != nil {
+				console.Logf("Error while processing '%v -> %v': %v\n", message.Topic(), string(message.Payload()), err)
+				return
+			}
+
+			// update state
+			as.publishChangedProps(l, []string{"rgb"})
 		},
 
-		"main/ct/set": func(client mqtt.Client, message mqtt.Message) {
+		"main/color_hsv/set": func(ctx context.Context, message mqtt.Message) {
 			// verify payload
-			ct, err := strconv.Atoi(string(message.Payload()))
+			hue, sat, bright, err := api.ParseHSV(string(message.Payload()))
+			if err != nil {
+				console.Logf("'%v -> %v': Error while parsing the color: %v\n", message.Topic(), string(message.Payload()), err)
+				return
+			}
+
+			// change stuff, in one step, the value 0 turns the light off
+			on := bright > 0
+			change := api.Change{On: &on, Transition: as.transition(ctx, l)}
+			if on {
+				change.Hue, change.Sat, change.Bright = &hue, &sat, &bright
+			}
+			err = l.ApplyCtx(ctx, change)
+			if err != nil {
+				console.Logf("Error while processing '%v -> %v': %v\n", message.Topic(), string(message.Payload()), err)
+				return
+			}
+
+			// update state
+			as.publishChangedProps(l, []string{"power", "hue", "sat", "bright", "color_mode"})
+		},
+
+		"main/sat/set": func(ctx context.Context, message mqtt.Message) {
+			// verify payload
+			sat, err := strconv.ParseUint(string(message.Payload()), 10, 8)
 			if err != nil {
 				console.Logf("'%v -> %v': Error while converting to int: %v\n", message.Topic(), string(message.Payload()), err)
 				return
 			}
 
 			// change stuff
-			err = l.SetCtAbx(uint(ct), "smooth", "500")
+			effect, duration := as.transition(ctx, l).Params()
+			err = l.SetHSVCtx(ctx, l.GetState().Hue, uint8(sat), effect, duration)
 			if err != nil {
 				console.Logf("Error while processing '%v -> %v': %v\n", message.Topic(), string(message.Payload()), err)
 				return
 			}
 
 			// update state
-			as.publishSingleProp(l, "main/ct", fmt.Sprintf("%v", l.GetState().Ct))
+			as.publishChangedProps(l, []string{"sat", "color_mode"})
+
 		},
 
-		"main/rgb/set": func(client mqtt.Client, message mqtt.Message) {
+		"main/color_mode/set": func(ctx context.Context, message mqtt.Message) {
 			// verify payload
-			rgb, err := strconv.Atoi(string(message.Payload()))
+			colorMode, err := api.ColorModeFromString(string(message.Payload()))
+			if err != nil {
+				console.Logf("'%v -> %v': Error while converting to colorMode: %v\n", message.Topic(), string(message.Payload()), err)
+				return
+			}
+
+			// change stuff
+			effect, duration := as.transition(ctx, l).Params()
+			powerMode := ""
+			switch colorMode {
+			case api.ColorModeRGB:
+				powerMode = "2"
+			case api.ColorModeHSV:
+				powerMode = "3"
+			case api.ColorModeCT:
+				powerMode = "1"
+			case api.ColorModeFlow:
+				powerMode = "4"
+			}
+
+			state := ""
+			if l.GetState().On {
+				state = "on"
+			} else {
+				state = "off"
+			}
+
+			err = l.SetPowerCtx(ctx, state, effect, duration, powerMode)
+			if err != nil {
+				console.Logf("Error while processing '%v -> %v': %v\n", message.Topic(), string(message.Payload()), err)
+			}
+
+			// update state
+			as.publishSingleProp(l, "main/color_mode", fmt.Sprintf("%v", l.GetState().Color_Mode))
+		},
+
+		"main/flowing/set": func(ctx context.Context, message mqtt.Message) {
+			// change stuff
+			var err error
+			switch string(message.Payload()) {
+			case "true":
+				// restart the last flow, the light remembers it
+				flow := l.GetState().Flow_Params
+				if len(flow.Steps) == 0 {
+					console.Logf("Error while processing '%v -> %v': no flow to start, set main/flow_params first\n", message.Topic(), string(message.Payload()))
+					return
+				}
+				err = l.StartFlowCtx(ctx, flow)
+			case "false":
+				err = l.StopCfCtx(ctx)
+			default:
+				console.Logf("Error while processing '%v -> %v': not 'true' or 'false'\n", message.Topic(), string(message.Payload()))
+				return
+			}
+			if err != nil {
+				console.Logf("Error while processing '%v -> %v': %v\n", message.Topic(), string(message.Payload()), err)
+				return
+			}
+
+			// update state
+			as.publishChangedProps(l, []string{"flowing", "flow_params"})
+		},
+
+		"main/delayoff/set": func(ctx context.Context, message mqtt.Message) {
+			// verify payload
+			minutes, err := strconv.Atoi(string(message.Payload()))
 			if err != nil {
 				console.Logf("'%v -> %v': Error while converting to int: %v\n", message.Topic(), string(message.Payload()), err)
 				return
 			}
-
-			// change stuff
-			err = l.SetRGB(uint32(rgb), "smooth", "500")
+
+			// change stuff, 0 cancels the timer
+			if minutes == 0 {
+				err = l.CronDelCtx(ctx, "0")
+			} else {
+				err = l.CronAddCtx(ctx, strconv.Itoa(minutes))
+			}
+			if err != nil {
+				console.Logf("Error while processing '%v -> %v': %v\n", message.Topic(), string(message.Payload()), err)
+				return
+			}
+
+			// update state
+			as.publishSingleProp(l, "main/delayoff", fmt.Sprintf("%v", l.GetState().Delayoff))
+			as.followDelayoff(l)
+		},
+
+		"main/flow_params/set": func(ctx context.Context, message mqtt.Message) {
+			// verify payload, either JSON or the format of the flow_params property
+			flow, err := api.ParseFlow(string(message.Payload()))
+			if err != nil {
+				console.Logf("'%v -> %v': Error while parsing the flow: %v\n", message.Topic(), string(message.Payload()), err)
+				return
+			}
+
+			// change stuff
+			err = l.StartFlowCtx(ctx, flow)
+			if err != nil {
+				console.Logf("Error while processing '%v -> %v': %v\n", message.Topic(), string(message.Payload()), err)
+				return
+			}
+
+			// update state
+			as.publishChangedProps(l, []string{"flowing", "flow_params"})
+		},
+
+		"main/music_on/set": func(ctx context.Context, message mqtt.Message) {
+			// change stuff
+			var err error
+			switch string(message.Payload()) {
+			case "true":
+				err = l.EnableMusicModeCtx(ctx)
+			case "false":
+				err = l.DisableMusicModeCtx(ctx)
+			default:
+				console.Logf("Error while processing '%v -> %v': not 'true' or 'false'\n", message.Topic(), string(message.Payload()))
+				return
+			}
+			if err != nil {
+				console.Logf("Error while processing '%v -> %v': %v\n", message.Topic(), string(message.Payload()), err)
+				return
+			}
+
+			// update state
+			as.publishSingleProp(l, "main/music_on", fmt.Sprintf("%v", l.GetState().Music_On))
+		},
+
+		// "set/main/name" : func(ctx context.Context, message mqtt.Message) {},
+
+		"main/nl_br/set": func(ctx context.Context, message mqtt.Message) {
+			// verify payload
+			brightness, err := strconv.Atoi(string(message.Payload()))
+			if err != nil {
+				console.Logf("Error while processing '%v -> %v': %v\n", message.Topic(), string(message.Payload()), err)
+				return
+			}
+			if brightness < 1 || brightness > 100 {
+				console.Logf("Error while processing '%v -> %v': brightness out of range\n", message.Topic(), string(message.Payload()))
+				return
+			}
+
+			// change stuff, switch to moonlight first if needed
+			effect, duration := as.transition(ctx, l).Params()
+			err = l.EnableMoonlightBrightCtx(ctx, uint8(brightness), effect, duration)
+			if err != nil {
+				console.Logf("Error while processing '%v -> %v': %v\n", message.Topic(), string(message.Payload()), err)
+				return
+			}
+
+			// update state
+			as.publishChangedProps(l, []string{"power", "active_mode", "nl_br"})
+		},
+
+		"main/moonlight_on/set": func(ctx context.Context, message mqtt.Message) {
+			// change stuff, the daylight is restored when moonlight is turned off
+			effect, duration := as.transition(ctx, l).Params()
+			var err error
+			switch string(message.Payload()) {
+			case "true":
+				err = l.EnableMoonlightCtx(ctx, effect, duration)
+			case "false":
+				err = l.DisableMoonlightCtx(ctx, effect, duration)
+			default:
+				console.Logf("'%v -> %v': Error while converting to bool\n", message.Topic(), string(message.Payload()))
+				return
+			}
+			if err != nil {
+				console.Logf("Error while processing '%v -> %v': %v\n", message.Topic(), string(message.Payload()), err)
+				return
+			}
+
+			// update state
+			as.publishChangedProps(l, []string{"power", "active_mode", "bright", "ct", "rgb", "hue", "sat", "color_mode"})
+		},
+
+		"bg/scene/set": func(ctx context.Context, message mqtt.Message) {
+			// verify payload
+			scene, err := api.ParseScene(string(message.Payload()))
+			if err != nil {
+				console.Logf("'%v -> %v': Error while parsing the scene: %v\n", message.Topic(), string(message.Payload()), err)
+				return
+			}
+
+			// change stuff
+			err = l.BgSetSceneCtx(ctx, scene)
+			if err != nil {
+				console.Logf("Error while processing '%v -> %v': %v\n", message.Topic(), string(message.Payload()), err)
+				return
+			}
+
+			// update state, the scene might have changed any of these
+			as.publishChangedProps(l, []string{"bg_power", "bg_bright", "bg_ct", "bg_rgb", "bg_hue", "bg_lmode", "bg_flowing", "bg_flow_params"})
+		},
+
+		"bg/toggle/set": func(ctx context.Context, message mqtt.Message) {
+			// verify payload, only "true" does something
+			if string(message.Payload()) != "true" {
+				return
+			}
+
+			// change stuff
+			err := l.BgToggleCtx(ctx)
+			if err != nil {
+				console.Logf("Error while processing '%v -> %v': %v\n", message.Topic(), string(message.Payload()), err)
+				return
+			}
+
+			// update state
+			as.publishChangedProps(l, []string{"bg_power"})
+		},
+
+		"bg/default/set": func(ctx context.Context, message mqtt.Message) {
+			// verify payload, only "true" does something
+			if string(message.Payload()) != "true" {
+				return
+			}
+
+			// change stuff, the light remembers its current state after a power loss
+			err := l.BgSetDefaultCtx(ctx)
+			if err != nil {
+				console.Logf("Error while processing '%v -> %v': %v\n", message.Topic(), string(message.Payload()), err)
+				return
+			}
+		},
+
+		"bg/on/set": func(ctx context.Context, message mqtt.Message) {
+			// yeelight2mqtt internally uses bool as a bool (makes sense)
+			// but yeelights use string with 'on' or 'off' as a bool
+			// and the Homie specification requires a string with 'true' or 'false'
+			// just to clear up the confusion for anyone reading this
+
+			// verify and convert payload
+			var yeelightBool string
+			var internalBool bool
+			switch string(message.Payload()) {
+			case "true":
+				yeelightBool = "on"
+				internalBool = true
+			case "false":
+				yeelightBool = "off"
+				internalBool = false
+			default:
+				console.Logf("Error while processing '%v -> %v': not 'true' or 'false'\n", message.Topic(), string(message.Payload()))
+				return
+			}
+
+			// change stuff
+			effect, duration := as.transition(ctx, l).Params()
+			err := l.BgSetPowerCtx(ctx, yeelightBool, effect, duration, "")
+			if err != nil {
+				console.Logf("Error while processing '%v -> %v': %v\n", message.Topic(), string(message.Payload()), err)
+				return
+			}
+
+			// update state
+			state := l.GetState()
+			state.On = internalBool
+
+			as.publishSingleProp(l, "bg/on", fmt.Sprintf("%v", state.Bg_On))
+		},
+
+		"bg/flowing/set": func(ctx context.Context, message mqtt.Message) {
+			// change stuff
+			var err error
+			switch string(message.Payload()) {
+			case "true":
+				// restart the last flow, the light remembers it
+				flow := l.GetState().Bg_Flow_Params
+				if len(flow.Steps) == 0 {
+					console.Logf("Error while processing '%v -> %v': no flow to start, set bg/flow_params first\n", message.Topic(), string(message.Payload()))
+					return
+				}
+				err = l.BgStartFlowCtx(ctx, flow)
+			case "false":
+				err = l.BgStopCfCtx(ctx)
+			default:
+				console.Logf("Error while processing '%v -> %v': not 'true' or 'false'\n", message.Topic(), string(message.Payload()))
+				return
+			}
 			if err != nil {
 				console.Logf("Error while processing '%v -> %v': %v\n", message.Topic(), string(message.Payload()), err)
 				return
 			}
 
 			// update state
-			as.publishSingleProp(l, "main/rgb", fmt.Sprintf("%v", l.GetState().RGB))
+			as.publishChangedProps(l, []string{"bg_flowing", "bg_flow_params"})
 		},
 
-		"main/hue/set": func(client mqtt.Client, message mqtt.Message) {
-			// verify payload
-			hue, err := strconv.Atoi(string(message.Payload()))
+		"bg/flow_params/set": func(ctx context.Context, message mqtt.Message) {
+			// verify payload, either JSON or the format of the flow_params property
+			flow, err := api.ParseFlow(string(message.Payload()))
 			if err != nil {
-				console.Logf("'%v -> %v': Error while converting to int: %v\n", message.Topic(), string(message.Payload()), err)
+				console.Logf("'%v -> %v': Error while parsing the flow: %v\n", message.Topic(), string(message.Payload()), err)
 				return
 			}
 
 			// change stuff
-			err = l.SetHSV(uint16(hue), l.GetState().Sat, "smooth", "500")
+			err = l.BgStartFlowCtx(ctx, flow)
 			if err != nil {
 				console.Logf("Error while processing '%v -> %v': %v\n", message.Topic(), string(message.Payload()), err)
 				return
 			}
 
 			// update state
-			as.publishSingleProp(l, "main/hue", fmt.Sprintf("%v", l.GetState().Hue))
+			as.publishChangedProps(l, []string{"bg_flowing", "bg_flow_params"})
 		},
 
-		"main/sat/set": func(client mqtt.Client, message mqtt.Message) {
+		"bg/ct/set": func(ctx context.Context, message mqtt.Message) {
 			// verify payload
-			sat, err := strconv.Atoi(string(message.Payload()))
+			ct, err := strconv.ParseUint(string(message.Payload()), 10, 16)
 			if err != nil {
 				console.Logf("'%v -> %v': Error while converting to int: %v\n", message.Topic(), string(message.Payload()), err)
 				return
 			}
 
 			// change stuff
-			err = l.SetHSV(l.GetState().Hue, uint8(sat), "smooth", "500")
+			effect, duration := as.transition(ctx, l).Params()
+			err = l.BgSetCtAbxCtx(ctx, uint(ct), effect, duration)
 			if err != nil {
 				console.Logf("Error while processing '%v -> %v': %v\n", message.Topic(), string(message.Payload()), err)
 				return
 			}
 
 			// update state
-			as.publishSingleProp(l, "main/sat", fmt.Sprintf("%v", l.GetState().Sat))
-
+			as.publishChangedProps(l, []string{"bg_ct", "bg_lmode"})
 		},
 
-		"main/color_mode/set": func(client mqtt.Client, message mqtt.Message) {
+		"bg/color_mode/set": func(ctx context.Context, message mqtt.Message) {
 			// verify payload
 			colorMode, err := api.ColorModeFromString(string(message.Payload()))
 			if err != nil {
@@ -365,6 +1289,7 @@ func (as *AppState) subProp(l *api.Light) {
 			}
 
 			// change stuff
+			effect, duration := as.transition(ctx, l).Params()
 			powerMode := ""
 			switch colorMode {
 			case api.ColorModeRGB:
@@ -378,274 +1303,269 @@ func (as *AppState) subProp(l *api.Light) {
 			}
 
 			state := ""
-			if l.GetState().On {
+			if l.GetState().Bg_On {
 				state = "on"
 			} else {
 				state = "off"
 			}
 
-			err = l.SetPower(state, "smooth", "500", powerMode)
+			err = l.BgSetPowerCtx(ctx, state, effect, duration, powerMode)
 			if err != nil {
 				console.Logf("Error while processing '%v -> %v': %v\n", message.Topic(), string(message.Payload()), err)
 			}
 
 			// update state
-			as.publishSingleProp(l, "main/color_mode", fmt.Sprintf("%v", l.GetState().Color_Mode))
-		},
-
-		"main/flowing/set": func(client mqtt.Client, message mqtt.Message) {
-			// TODO not implemented yet
-			console.Logf("%v not implemented yet, ignoring\n", "main/flowing/set")
-			// verify payload
-
-			// change stuff
-
-			// update state
-		},
-
-		"main/delayoff/set": func(client mqtt.Client, message mqtt.Message) {
-			// TODO not implemented yet
-			console.Logf("%v not implemented yet, ignoring\n", "main/delayoff/set")
-			// verify payload
-
-			// change stuff
-
-			// update state
+			as.publishSingleProp(l, "bg/color_mode", fmt.Sprintf("%v", l.GetState().Bg_Color_Mode))
 		},
 
-		"main/flow_params/set": func(client mqtt.Client, message mqtt.Message) {
-			// TODO not implemented yet
-			console.Logf("%v not implemented yet, ignoring\n", "main/flow_params/set")
+		"bg/bright/set": func(ctx context.Context, message mqtt.Message) {
 			// verify payload
+			brightness, err := strconv.ParseUint(string(message.Payload()), 10, 8)
+			if err != nil {
+				console.Logf("Error while processing '%v -> %v': %v\n", message.Topic(), string(message.Payload()), err)
+				return
+			}
 
 			// change stuff
+			effect, duration := as.transition(ctx, l).Params()
+			err = l.BgSetBrightCtx(ctx, uint8(brightness), effect, duration)
+			if err != nil {
+				console.Logf("Error while processing '%v -> %v': %v\n", message.Topic(), string(message.Payload()), err)
+				return
+			}
 
 			// update state
+			as.publishSingleProp(l, "bg/bright", fmt.Sprintf("%v", l.GetState().Bg_Bright))
 		},
 
-		// "set/main/music_on" : func(client mqtt.Client, message mqtt.Message) {},
-		// "set/main/name" : func(client mqtt.Client, message mqtt.Message) {},
-
-		"main/nl_br/set": func(client mqtt.Client, message mqtt.Message) {
-			// TODO not implemented yet
-			console.Logf("%v not implemented yet, ignoring\n", "main/nl_br/set")
-
+		"bg/rgb/set": func(ctx context.Context, message mqtt.Message) {
 			// verify payload
+			rgb, err := api.ParseRGB(string(message.Payload()))
+			if err != nil {
+				console.Logf("'%v -> %v': Error while parsing the color: %v\n", message.Topic(), string(message.Payload()), err)
+				return
+			}
 
 			// change stuff
+			effect, duration := as.transition(ctx, l).Params()
+			err = l.BgSetRGBCtx(ctx, rgb, effect, duration)
+			if err != nil {
+				console.Logf("Error while processing '%v -> %v': %v\n", message.Topic(), string(message.Payload()), err)
+				return
+			}
 
 			// update state
+			as.publishChangedProps(l, []string{"bg_rgb", "bg_lmode"})
 		},
 
-		"main/moonlight_on/set": func(client mqtt.Client, message mqtt.Message) {
+		"bg/hue/set": func(ctx context.Context, message mqtt.Message) {
 			// verify payload
-			str := string(message.Payload())
-			mode := ""
-
-			switch str {
-			case "true":
-				mode = "5"
-			case "false":
-				mode = "0"
-			default:
-				console.Logf("'%v -> %v': Error while converting to bool\n", message.Topic(), string(message.Payload()))
+			hue, err := strconv.ParseUint(string(message.Payload()), 10, 16)
+			if err != nil {
+				console.Logf("'%v -> %v': Error while converting to int: %v\n", message.Topic(), string(message.Payload()), err)
 				return
 			}
 
 			// change stuff
-			state := ""
-			if l.GetState().On {
-				state = "on"
-			} else {
-				state = "off"
-			}
-
-			err := l.SetPower(state, "smooth", "500", mode)
+			effect, duration := as.transition(ctx, l).Params()
+			err = l.BgSetHSVCtx(ctx, uint16(hue), l.GetState().Bg_Sat, effect, duration)
 			if err != nil {
 				console.Logf("Error while processing '%v -> %v': %v\n", message.Topic(), string(message.Payload()), err)
 				return
 			}
 
 			// update state
-			as.publishSingleProp(l, "main/moonlight_on", fmt.Sprintf("%v", l.GetState().Moonlight_On))
+			as.publishChangedProps(l, []string{"bg_hue", "bg_lmode"})
 		},
 
-		"bg/on/set": func(client mqtt.Client, message mqtt.Message) {
-			// yeelight2mqtt internally uses bool as a bool (makes sense)
-			// but yeelights use string with 'on' or 'off' as a bool
-			// and the Homie specification requires a string with 'true' or 'false'
-			// just to clear up the confusion for anyone reading this
-
-			// verify and convert payload
-			var yeelightBool string
-			var internalBool bool
-			switch string(message.Payload()) {
-			case "true":
-				yeelightBool = "on"
-				internalBool = true
-			case "false":
-				yeelightBool = "off"
-				internalBool = false
-			default:
-				console.Logf("Error while processing '%v -> %v': not 'true' or 'false'\n", message.Topic(), string(message.Payload()))
+		"bg/color/set": func(ctx context.Context, message mqtt.Message) {
+			// verify payload, r,g,b is expected, but #RRGGBB or a color name work as well
+			rgb, err := api.ParseRGB(string(message.Payload()))
+			if err != nil {
+				console.Logf("'%v -> %v': Error while parsing the color: %v\n", message.Topic(), string(message.Payload()), err)
 				return
 			}
 
 			// change stuff
-			err := l.BgSetPower(yeelightBool, "smooth", "500", "")
+			effect, duration := as.transition(ctx, l).Params()
+			err = l.BgSetRGBCtx(ctx, rgb, effect, duration)
 			if err != nil {
 				console.Logf("Error while processing '%v -> %v': %v\n", message.Topic(), string(message.Payload()), err)
 				return
 			}
 
 			// update state
-			state := l.GetState()
-			state.On = internalBool
-
-			as.publishSingleProp(l, "bg/on", fmt.Sprintf("%v", state.Bg_On))
-		},
-
-		"bg/flowing/set": func(client mqtt.Client, message mqtt.Message) {
-			// TODO not implemented yet
-			console.Logf("%v not implemented yet, ignoring\n", "bg/flowing/set")
-			// verify payload
-
-			// change stuff
-
-			// update state
+			as.publishChangedProps(l, []string{"bg_rgb"})
 		},
 
-		"bg/flow_params/set": func(client mqtt.Client, message mqtt.Message) {
-			// TODO not implemented yet
-			console.Logf("%v not implemented yet, ignoring\n", "bg/flow_params/set")
+		"bg/color_hsv/set": func(ctx context.Context, message mqtt.Message) {
 			// verify payload
+			hue, sat, bright, err := api.ParseHSV(string(message.Payload()))
+			if err != nil {
+				console.Logf("'%v -> %v': Error while parsing the color: %v\n", message.Topic(), string(message.Payload()), err)
+				return
+			}
 
-			// change stuff
+			// change stuff, the value 0 turns the light off, and a scene turns it on in one step
+			effect, duration := as.transition(ctx, l).Params()
+			switch {
+			case bright == 0:
+				err = l.BgSetPowerCtx(ctx, "off", effect, duration, "")
+			case !l.GetState().Bg_On:
+				err = l.BgSetSceneCtx(ctx, api.HSVScene{Hue: hue, Sat: sat, Bright: bright})
+			default:
+				err = l.BgSetHSVCtx(ctx, hue, sat, effect, duration)
+				if err == nil {
+					err = l.BgSetBrightCtx(ctx, bright, effect, duration)
+				}
+			}
+			if err != nil {
+				console.Logf("Error while processing '%v -> %v': %v\n", message.Topic(), string(message.Payload()), err)
+				return
+			}
 
 			// update state
+			as.publishChangedProps(l, []string{"bg_power", "bg_hue", "bg_sat", "bg_bright", "bg_lmode"})
 		},
 
-		"bg/ct/set": func(client mqtt.Client, message mqtt.Message) {
+		"bg/sat/set": func(ctx context.Context, message mqtt.Message) {
 			// verify payload
-			ct, err := strconv.Atoi(string(message.Payload()))
+			sat, err := strconv.ParseUint(string(message.Payload()), 10, 8)
 			if err != nil {
 				console.Logf("'%v -> %v': Error while converting to int: %v\n", message.Topic(), string(message.Payload()), err)
 				return
 			}
 
 			// change stuff
-			err = l.BgSetCtAbx(uint(ct), "smooth", "500")
+			effect, duration := as.transition(ctx, l).Params()
+			err = l.BgSetHSVCtx(ctx, l.GetState().Bg_Hue, uint8(sat), effect, duration)
 			if err != nil {
 				console.Logf("Error while processing '%v -> %v': %v\n", message.Topic(), string(message.Payload()), err)
 				return
 			}
 
 			// update state
-			as.publishSingleProp(l, "bg/ct", fmt.Sprintf("%v", l.GetState().Bg_Ct))
+			as.publishChangedProps(l, []string{"bg_sat", "bg_lmode"})
 		},
-
-		"bg/color_mode/set": func(client mqtt.Client, message mqtt.Message) {
+		"main/bright_adjust/set": func(ctx context.Context, message mqtt.Message) {
 			// verify payload
-			colorMode, err := api.ColorModeFromString(string(message.Payload()))
+			percentage, duration, err := parseAdjustment(string(message.Payload()), as.transition(ctx, l))
 			if err != nil {
-				console.Logf("'%v -> %v': Error while converting to colorMode: %v\n", message.Topic(), string(message.Payload()), err)
+				console.Logf("'%v -> %v': Error while parsing the adjustment: %v\n", message.Topic(), string(message.Payload()), err)
 				return
 			}
 
-			// change stuff
-			powerMode := ""
-			switch colorMode {
-			case api.ColorModeRGB:
-				powerMode = "2"
-			case api.ColorModeHSV:
-				powerMode = "3"
-			case api.ColorModeCT:
-				powerMode = "1"
-			case api.ColorModeFlow:
-				powerMode = "4"
+			// change stuff, the new value is published once the light notifies about it
+			err = l.AdjustBrightCtx(ctx, percentage, duration)
+			if err != nil {
+				console.Logf("Error while processing '%v -> %v': %v\n", message.Topic(), string(message.Payload()), err)
 			}
+		},
 
-			state := ""
-			if l.GetState().Bg_On {
-				state = "on"
-			} else {
-				state = "off"
+		"main/ct_adjust/set": func(ctx context.Context, message mqtt.Message) {
+			// verify payload
+			percentage, duration, err := parseAdjustment(string(message.Payload()), as.transition(ctx, l))
+			if err != nil {
+				console.Logf("'%v -> %v': Error while parsing the adjustment: %v\n", message.Topic(), string(message.Payload()), err)
+				return
 			}
 
-			err = l.BgSetPower(state, "smooth", "500", powerMode)
+			// change stuff, the new value is published once the light notifies about it
+			err = l.AdjustCtCtx(ctx, percentage, duration)
 			if err != nil {
 				console.Logf("Error while processing '%v -> %v': %v\n", message.Topic(), string(message.Payload()), err)
 			}
-
-			// update state
-			as.publishSingleProp(l, "bg/color_mode", fmt.Sprintf("%v", l.GetState().Bg_Color_Mode))
 		},
 
-		"bg/bright/set": func(client mqtt.Client, message mqtt.Message) {
+		"main/color_adjust/set": func(ctx context.Context, message mqtt.Message) {
 			// verify payload
-			brightness, err := strconv.Atoi(string(message.Payload()))
+			percentage, duration, err := parseAdjustment(string(message.Payload()), as.transition(ctx, l))
 			if err != nil {
-				console.Logf("Error while processing '%v -> %v': %v\n", message.Topic(), string(message.Payload()), err)
-				return
-			}
-			if brightness > math.MaxUint8 {
-				console.Logf("Error while processing '%v -> %v': brightness too high\n", message.Topic(), string(message.Payload()))
+				console.Logf("'%v -> %v': Error while parsing the adjustment: %v\n", message.Topic(), string(message.Payload()), err)
 				return
 			}
 
-			// change stuff
-			err = l.BgSetBright(uint8(brightness), "smooth", "500")
+			// change stuff, the new value is published once the light notifies about it
+			err = l.AdjustColorCtx(ctx, percentage, duration)
 			if err != nil {
 				console.Logf("Error while processing '%v -> %v': %v\n", message.Topic(), string(message.Payload()), err)
-				return
 			}
-
-			// update state
-			as.publishSingleProp(l, "bg/bright", fmt.Sprintf("%v", l.GetState().Bg_Bright))
 		},
 
-		"bg/rgb/set": func(client mqtt.Client, message mqtt.Message) {
+		"bg/bright_adjust/set": func(ctx context.Context, message mqtt.Message) {
 			// verify payload
-			rgb, err := strconv.Atoi(string(message.Payload()))
+			percentage, duration, err := parseAdjustment(string(message.Payload()), as.transition(ctx, l))
 			if err != nil {
-				console.Logf("'%v -> %v': Error while converting to int: %v\n", message.Topic(), string(message.Payload()), err)
+				console.Logf("'%v -> %v': Error while parsing the adjustment: %v\n", message.Topic(), string(message.Payload()), err)
 				return
 			}
 
-			// change stuff
-			err = l.BgSetRGB(uint32(rgb), "smooth", "500")
+			// change stuff, the new value is published once the light notifies about it
+			err = l.BgAdjustBrightCtx(ctx, percentage, duration)
 			if err != nil {
 				console.Logf("Error while processing '%v -> %v': %v\n", message.Topic(), string(message.Payload()), err)
-				return
 			}
-
-			// update state
-			as.publishSingleProp(l, "bg/rgb", fmt.Sprintf("%v", l.GetState().Bg_RGB))
 		},
 
-		"bg/hue/set": func(client mqtt.Client, message mqtt.Message) {
+		"bg/ct_adjust/set": func(ctx context.Context, message mqtt.Message) {
 			// verify payload
-			hue, err := strconv.Atoi(string(message.Payload()))
+			percentage, duration, err := parseAdjustment(string(message.Payload()), as.transition(ctx, l))
 			if err != nil {
-				console.Logf("'%v -> %v': Error while converting to int: %v\n", message.Topic(), string(message.Payload()), err)
+				console.Logf("'%v -> %v': Error while parsing the adjustment: %v\n", message.Topic(), string(message.Payload()), err)
 				return
 			}
 
-			// change stuff
-			err = l.BgSetHSV(uint16(hue), l.GetState().Bg_Sat, "smooth", "500")
+			// change stuff, the new value is published once the light notifies about it
+			err = l.BgAdjustCtCtx(ctx, percentage, duration)
 			if err != nil {
 				console.Logf("Error while processing '%v -> %v': %v\n", message.Topic(), string(message.Payload()), err)
+			}
+		},
+
+		"bg/color_adjust/set": func(ctx context.Context, message mqtt.Message) {
+			// verify payload
+			percentage, duration, err := parseAdjustment(string(message.Payload()), as.transition(ctx, l))
+			if err != nil {
+				console.Logf("'%v -> %v': Error while parsing the adjustment: %v\n", message.Topic(), string(message.Payload()), err)
 				return
 			}
 
-			// update state
-			as.publishSingleProp(l, "bg/hue", fmt.Sprintf("%v", l.GetState().Bg_Hue))
+			// change stuff, the new value is published once the light notifies about it
+			err = l.BgAdjustColorCtx(ctx, percentage, duration)
+			if err != nil {
+				console.Logf("Error while processing '%v -> %v': %v\n", message.Topic(), string(message.Payload()), err)
+			}
 		},
 	}
 
 	baseTopic := fmt.Sprintf("%v/%v/", as.MQTTSettings.BaseTopic, l.Name)
 
-	for topic, callback := range topicsToSubscribe {
+	for topic, handler := range topicsToSubscribe {
+		// don't offer what the light can't do, e.g. setting the color of a white bulb
+		if !hasProperty(l, strings.TrimSuffix(topic, "/set")) {
+			continue
+		}
+
+		handler := handler
+		callback := func(client mqtt.Client, message mqtt.Message) {
+			// don't wait forever for a light that is unplugged
+			ctx, cancel := context.WithTimeout(context.Background(), mqttCommandTimeout)
+			defer cancel()
+
+			// the transition of this message only, e.g. "50?transition=3s"
+			payload, transition, err := cutTransition(string(message.Payload()))
+			if err != nil {
+				console.Logf("'%v -> %v': Error while parsing the transition: %v\n", message.Topic(), string(message.Payload()), err)
+				return
+			}
+			if transition != nil {
+				ctx = context.WithValue(ctx, transitionKey{}, *transition)
+				message = transitionMessage{Message: message, payload: []byte(payload)}
+			}
+
+			handler(ctx, message)
+		}
+
 		token := as.mqttClient.Subscribe(baseTopic+topic, 2, callback)
 		token.WaitTimeout(time.Second)
 		if err := token.Error(); err != nil {
@@ -654,6 +1574,27 @@ func (as *AppState) subProp(l *api.Light) {
 	}
 }
 
+// parseAdjustment parses the payload of the adjust properties (e.g. main/bright_adjust), a signed percentage optionally followed by
+// the duration in milliseconds, e.g. "-10" or "+20,1000". The duration of the transition is used if it's left out.
+func parseAdjustment(payload string, transition api.Transition) (int8, string, error) {
+	pct, duration, found := strings.Cut(payload, ",")
+	if !found {
+		_, duration = transition.Params()
+	}
+
+	percentage, err := strconv.ParseInt(strings.TrimSpace(pct), 10, 8)
+	if err != nil || percentage < -100 || percentage > 100 {
+		return 0, "", fmt.Errorf("percentage must be between -100 and 100")
+	}
+
+	ms, err := strconv.ParseUint(strings.TrimSpace(duration), 10, 32)
+	if err != nil || ms < 30 {
+		return 0, "", fmt.Errorf("duration must be at least 30 ms")
+	}
+
+	return int8(percentage), strconv.FormatUint(ms, 10), nil
+}
+
 func (as *AppState) LoadFromYAML(filename string) error {
 	f, err := os.ReadFile(filename)
 	if err != nil {
@@ -684,14 +1625,15 @@ func (as *AppState) SaveToYAML(filename string) error {
 
 func CreateConfig(filename string) error {
 	defaultConfig := AppState{
-		Lights: []api.Light{
+		Lights: []*api.Light{
 			{
 				Host: "192.168.50.2",
 				Name: "light-1-example",
 			},
 			{
-				Host: "192.168.50.3",
-				Name: "light-2-example",
+				ID:         "0x000000000015243f",
+				Name:       "light-2-example",
+				Transition: api.Transition{Effect: "smooth", Duration: 3000},
 			},
 		},
 		MQTTSettings: MQTTSettings{
@@ -703,15 +1645,211 @@ func CreateConfig(filename string) error {
 			QoS:       2,
 		},
 		LightPollingRate: PollingRate{Seconds: 10},
+		Discovery: DiscoverySettings{
+			Enabled:     true,
+			WaitSeconds: 3,
+		},
+		HomeAssistant: HomeAssistantSettings{
+			Enabled:         false,
+			DiscoveryPrefix: "homeassistant",
+			BaseTopic:       "yeelight2mqtt",
+		},
 	}
 
 	return defaultConfig.SaveToYAML(filename)
 }
 
-// Receive MQTT messages and push the changes to the lights accordingly
+// Homie IDs may only contain lowercase letters, numbers and hyphens
+func homieID(str string) string {
+	id := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '-':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r - 'A' + 'a'
+		}
+		return '-'
+	}, str)
+	return strings.Trim(id, "-")
+}
+
+// lights returns the lights in the config, including the ones adopted so far
+func (as *AppState) lights() []*api.Light {
+	as.lightsMutex.Lock()
+	defer as.lightsMutex.Unlock()
+	return append([]*api.Light{}, as.Lights...)
+}
+
+// Search the local network for lights, learn the addresses of lights configured by their ID (and the IDs
+// of lights configured by their address), and if adopt is true, add the lights that aren't in the config yet
+func (as *AppState) discoverLights(adopt bool) error {
+	wait := as.Discovery.WaitSeconds
+	if wait == 0 {
+		wait = 3
+	}
+
+	console.Logln("Searching for lights on the local network...")
+	ads, err := api.Discover(time.Duration(wait) * time.Second)
+	if err != nil {
+		return err
+	}
+
+	adopted := false
+	for _, ad := range ads {
+		if as.handleAdvertisement(ad, adopt) != nil {
+			adopted = true
+		}
+	}
+	if adopted {
+		as.saveAdoptedLights()
+	}
+
+	for _, light := range as.lights() {
+		if light.GetHost() == "" {
+			console.Logf("Light '%v' (id %v) wasn't found, waiting for it to advertise itself\n", light.Name, light.GetID())
+		}
+	}
+
+	return nil
+}
+
+/*
+Match the advertisement to the light it belongs to: a light configured by its ID is pointed at the address in the
+advertisement, a light configured by its address learns its ID, and the model and the supported methods are
+filled in, unless they're configured.
+
+If no light matches and adopt is true, the light is added to the config. The adopted light is returned, it has to
+be started (see startLight) once the other lights are.
+*/
+func (as *AppState) handleAdvertisement(ad api.Advertisement, adopt bool) *api.Light {
+	as.lightsMutex.Lock()
+	defer as.lightsMutex.Unlock()
+
+	for _, light := range as.Lights {
+		if !api.SameID(light.GetID(), ad.ID) {
+			continue
+		}
+
+		if light.GetHost() != ad.Host {
+			console.Logf("Light '%v' (id %v) is now at %v\n", light.Name, light.GetID(), ad.Host)
+			light.SetHost(ad.Host)
+		}
+		light.Describe(ad)
+		return nil
+	}
+
+	// the light may be configured just by its address
+	for _, light := range as.Lights {
+		if light.GetID() == "" && light.GetHost() == ad.Host {
+			light.Describe(ad)
+			return nil
+		}
+	}
+
+	if !adopt {
+		return nil
+	}
+
+	name := homieID(ad.Name)
+	taken := name == ""
+	for _, light := range as.Lights {
+		taken = taken || light.Name == name
+	}
+	if taken {
+		name = "yeelight-" + strings.TrimLeft(strings.TrimPrefix(ad.ID, "0x"), "0")
+	}
+
+	light := &api.Light{
+		Host:    ad.Host,
+		Name:    name,
+		ID:      ad.ID,
+		Model:   ad.Model,
+		Support: ad.Support,
+	}
+	as.Lights = append(as.Lights, light)
+	console.Logf("Adopted light '%v' (model %v, id %v) at %v\n", name, ad.Model, ad.ID, ad.Host)
+	return light
+}
+
+// Save the config with the adopted lights, so they keep their names (and the IDs they're found by)
+// when yeelight2mqtt is restarted
+func (as *AppState) saveAdoptedLights() {
+	as.lightsMutex.Lock()
+	defer as.lightsMutex.Unlock()
+
+	err := as.SaveToYAML(configFile)
+	if err != nil {
+		console.Logf("Error while saving the adopted lights to %v: %v\n", configFile, err)
+	}
+}
+
+// Start handling the light: follow its notifications, publish it and subscribe to its MQTT topics.
+// The light is polled by stateDaemon.
+func (as *AppState) startLight(light *api.Light) {
+	light.SetRefreshCallback(func(props []string) {
+		as.publishChangedProps(light, props)
+	})
+	as.publishSingleProp(light, "$state", stateInit)
+	as.startHomeAssistantLight(light)
+	go light.RefreshDaemon()
+	as.subProp(light)
+}
+
+// Adopt or update the light the advertisement belongs to, the adopted light is started and saved to the config
+func (as *AppState) followAdvertisement(ad api.Advertisement) {
+	light := as.handleAdvertisement(ad, as.Discovery.Enabled)
+	if light == nil {
+		return
+	}
+
+	as.startLight(light)
+	as.saveAdoptedLights()
+}
+
+// Listen for the advertisements the lights send when they join the network (e.g. after a DHCP lease change)
+func (as *AppState) followAdvertisements() {
+	for {
+		err := api.ListenAdvertisements(as.followAdvertisement)
+		console.Logf("Error while listening for advertisements of the lights, retrying in a minute: %v\n", err)
+		time.Sleep(time.Minute)
+	}
+}
+
+// Search for the lights again in the background, at most once per minute.
+// Used when a light configured by its ID stops responding, since it might have changed its address.
+func (as *AppState) rediscoverLights() {
+	if !atomic.CompareAndSwapInt32(&as.searching, 0, 1) {
+		return
+	}
+
+	go func() {
+		defer atomic.StoreInt32(&as.searching, 0)
+
+		if time.Since(as.lastSearch) < time.Minute {
+			return
+		}
+		as.lastSearch = time.Now()
+
+		wait := as.Discovery.WaitSeconds
+		if wait == 0 {
+			wait = 3
+		}
+
+		ads, err := api.Discover(time.Duration(wait) * time.Second)
+		if err != nil {
+			console.Logf("Error while searching for lights: %v\n", err)
+			return
+		}
+		for _, ad := range ads {
+			as.followAdvertisement(ad)
+		}
+	}()
+}
+
+// Receive MQTT messages and push the changes to the lights accordingly, see startLight
 func (as *AppState) statePushDaemon() {
-	for k := range as.Lights {
-		as.subProp(&as.Lights[k])
+	for _, light := range as.lights() {
+		as.startLight(light)
 	}
 	console.Logln("Subscribed to MQTT messages for the lights!")
 }
@@ -723,21 +1861,65 @@ func (as *AppState) stateDaemon() {
 	console.Logf("Initial poll starting...\n")
 
 	go func() {
+		// when was music mode last tried to be enabled for each light
+		musicAttempts := make(map[*api.Light]time.Time)
 
 		for {
 			select {
 			case <-ticker.C:
 				// poll every light and publish the properties
-				for k := range as.Lights {
-					err := as.Lights[k].GetProp()
-					if err != nil {
+				for _, light := range as.lights() {
+					// a light that hasn't answered lately is given a break
+					if time.Now().Before(as.health.get(light).nextPoll) {
+						continue
+					}
+
+					// the light closes the music connection when it's turned off using a switch, try to get it back
+					if light.Music && !light.MusicMode() && time.Since(musicAttempts[light]) > time.Minute {
+						musicAttempts[light] = time.Now()
+						ctx, cancel := context.WithTimeout(context.Background(), pollTimeout)
+						err := light.EnableMusicModeCtx(ctx)
+						cancel()
+						if err != nil {
+							console.Logf("Error while enabling music mode of light '%v': %v\n", light.Name, err)
+						}
+					}
+
+					var cmdErr *api.CommandError
+					ctx, cancel := context.WithTimeout(context.Background(), pollTimeout)
+					err := light.GetPropCtx(ctx)
+					cancel()
+					switch {
+					case errors.Is(err, api.ErrQuotaExceeded):
+						// the commands from MQTT have used up the quota, the light will be polled next time
+						if as.Debug {
+							console.Logf("Skipped polling light '%v': %v\n", light.Name, err)
+						}
+						continue
+					case errors.Is(err, api.ErrInvalidProp):
+						// the rest of the properties is fine
+						console.Logf("Light '%v': %v\n", light.Name, err)
+						as.setHealth(light, stateAlert, err)
+					case errors.As(err, &cmdErr):
+						// the light is reachable, it just didn't like the command
 						console.Logln(err)
+						as.setHealth(light, stateAlert, err)
 						continue
+					case err != nil:
+						console.Logln(err)
+						as.setHealth(light, stateLost, err)
+						// the light might have changed its address
+						if light.GetID() != "" {
+							as.rediscoverLights()
+						}
+						continue
+					default:
+						as.setHealth(light, stateReady, nil)
 					}
 
-					as.publishProp(&as.Lights[k])
+					as.publishProp(light)
 					if as.Debug {
-						console.Logf("Polled light '%v' at %v\n", as.Lights[k].Name, time.Now())
+						console.Logf("Polled light '%v' at %v\n", light.Name, time.Now())
 					}
 				}
 			}
@@ -776,6 +1958,12 @@ func (as *AppState) mqttInit() error {
 	opts.SetUsername(as.MQTTSettings.User)
 	opts.SetPassword(as.MQTTSettings.Password)
 
+	// the broker publishes the will if the connection is lost without a goodbye, e.g. when yeelight2mqtt crashes
+	opts.SetWill(as.bridgeTopic(), bridgeOffline, byte(as.MQTTSettings.QoS), true)
+	opts.SetOnConnectHandler(func(client mqtt.Client) {
+		client.Publish(as.bridgeTopic(), byte(as.MQTTSettings.QoS), true, bridgeOnline)
+	})
+
 	console.Logf("Connecting to MQTT broker %v...\n", as.MQTTSettings.Host)
 	as.mqttClient = mqtt.NewClient(opts)
 	if token := as.mqttClient.Connect(); token.Wait() && token.Error() != nil {
@@ -787,8 +1975,8 @@ func (as *AppState) mqttInit() error {
 }
 
 func main() {
-	c := make(chan os.Signal)
-	signal.Notify(c, os.Interrupt)
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
 
 	if Version == "" {
 		Version = "v0.0.0"
@@ -817,11 +2005,11 @@ func main() {
 
 	as := AppState{}
 
-	err := as.LoadFromYAML("config.yaml")
+	err := as.LoadFromYAML(configFile)
 	if err != nil {
 		if errors.Is(err, os.ErrNotExist) {
 			console.Logln("Config.yaml doesn't exist, creating a sample config.yaml...")
-			err = CreateConfig("config.yaml")
+			err = CreateConfig(configFile)
 			if err != nil {
 				log.Fatalf("%v", err)
 			}
@@ -833,21 +2021,35 @@ func main() {
 		log.Fatalf("An error has occured while trying to load config.yaml: %v", err)
 	}
 
+	// lights configured only by their ID need to be searched for, even if discovery is disabled
+	search := as.Discovery.Enabled
+	for _, light := range as.Lights {
+		if light.Host == "" {
+			search = true
+		}
+	}
+	if search {
+		err = as.discoverLights(as.Discovery.Enabled)
+		if err != nil {
+			console.Logf("Error while searching for lights: %v\n", err)
+		}
+	}
+
 	err = as.mqttInit()
 	if err != nil {
 		log.Fatalf("An error has occured while trying to initialize MQTT: %v", err)
 	}
 
-	for k := range as.Lights {
-		as.Lights[k].SetRefreshCallback(func(message string) {
-			// TODO do stuff with the message
-			console.Logf("Received message from '%v': %v\n", as.Lights[k].Host, message)
-		})
-	}
-	api.RunRefreshDaemons(&as.Lights)
-	as.stateDaemon()
+	as.startHomeAssistant()
 	as.statePushDaemon()
+	as.stateDaemon()
+
+	// the lights found from now on are started right away
+	go as.followAdvertisements()
 
-	// block indefinitely
-	<-(chan int)(nil)
+	// run until interrupted
+	<-c
+	console.Logln("Exiting...")
+	as.publishDisconnected()
+	as.mqttClient.Disconnect(250)
 }
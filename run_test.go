@@ -0,0 +1,141 @@
+package main
+
+import (
+	"github.com/dsorm/yeelight2mqtt/api"
+	"github.com/dsorm/yeelight2mqtt/simulator"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"sync"
+	"testing"
+)
+
+// testClient stands in for the connection to the broker, it keeps the subscriptions and the published messages
+type testClient struct {
+	mqtt.Client
+
+	mutex     sync.Mutex
+	handlers  map[string]mqtt.MessageHandler
+	published map[string]interface{}
+}
+
+func (c *testClient) Subscribe(topic string, qos byte, callback mqtt.MessageHandler) mqtt.Token {
+	c.mutex.Lock()
+	c.handlers[topic] = callback
+	c.mutex.Unlock()
+	return &mqtt.DummyToken{}
+}
+
+func (c *testClient) Publish(topic string, qos byte, retained bool, payload interface{}) mqtt.Token {
+	c.mutex.Lock()
+	c.published[topic] = payload
+	c.mutex.Unlock()
+	return &mqtt.DummyToken{}
+}
+
+// receive passes the message to the handler subscribed to topic, it returns false if there is none
+func (c *testClient) receive(topic string, payload string) bool {
+	c.mutex.Lock()
+	handler, ok := c.handlers[topic]
+	c.mutex.Unlock()
+
+	if ok {
+		handler(c, testMessage{topic: topic, payload: []byte(payload)})
+	}
+	return ok
+}
+
+type testMessage struct {
+	mqtt.Message
+	topic   string
+	payload []byte
+}
+
+func (m testMessage) Topic() string {
+	return m.topic
+}
+
+func (m testMessage) Payload() []byte {
+	return m.payload
+}
+
+// newTestApp subscribes to the properties of a light supporting the given methods (every method if there are none),
+// which is simulated by the returned bulb
+func newTestApp(t *testing.T, support ...string) (*simulator.Bulb, *testClient) {
+	t.Helper()
+
+	bulb := simulator.New()
+	bulb.Support = support
+	if err := bulb.Listen("127.0.0.1:0"); err != nil {
+		t.Fatalf("Listen() failed: %v", err)
+	}
+	t.Cleanup(func() { bulb.Close() })
+
+	light := &api.Light{Host: bulb.Addr(), Name: "desk", Support: support}
+	light.SetRefreshCallback(func(props []string) {})
+
+	client := &testClient{
+		handlers:  make(map[string]mqtt.MessageHandler),
+		published: make(map[string]interface{}),
+	}
+	as := &AppState{
+		Lights:       []*api.Light{light},
+		MQTTSettings: MQTTSettings{BaseTopic: "homie"},
+		mqttClient:   client,
+	}
+	as.subProp(light)
+	return bulb, client
+}
+
+func TestSetProperty(t *testing.T) {
+	tests := []struct {
+		name    string
+		topic   string
+		payload string
+		props   map[string]string // of the bulb afterwards
+		publish map[string]string // nil if nothing should be published
+	}{
+		{"bright", "main/bright/set", "42", map[string]string{"bright": "42"}, map[string]string{"main/bright": "42"}},
+		{"bright with a transition", "main/bright/set", "42?transition=500", map[string]string{"bright": "42"}, map[string]string{"main/bright": "42"}},
+		{"invalid bright", "main/bright/set", "bright", map[string]string{"bright": "100"}, nil},
+		{"bright out of range", "main/bright/set", "0", map[string]string{"bright": "100"}, nil},
+		{"invalid transition", "main/bright/set", "42?transition=forever", map[string]string{"bright": "100"}, nil},
+		{"transition", "main/transition/set", "1500", nil, map[string]string{"main/transition": "1500"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			bulb, client := newTestApp(t)
+
+			if !client.receive("homie/desk/"+test.topic, test.payload) {
+				t.Fatalf("%v isn't subscribed to", test.topic)
+			}
+
+			for name, value := range test.props {
+				if got := bulb.Prop(name); got != value {
+					t.Errorf("%v of the bulb = %v, want %v", name, got, value)
+				}
+			}
+			if test.publish == nil && len(client.published) != 0 {
+				t.Errorf("published %v, want nothing", client.published)
+			}
+			for topic, value := range test.publish {
+				if got := client.published["homie/desk/"+topic]; got != value {
+					t.Errorf("%v = %v, want %v", topic, got, value)
+				}
+			}
+		})
+	}
+}
+
+func TestSubscribedProperties(t *testing.T) {
+	// a white bulb without an ambilight
+	_, client := newTestApp(t, "get_prop", "set_power", "set_bright", "set_ct_abx")
+	if !client.receive("homie/desk/main/bright/set", "42") {
+		t.Errorf("main/bright/set isn't subscribed to")
+	}
+	if client.receive("homie/desk/main/rgb/set", "255,0,0") {
+		t.Errorf("main/rgb/set of a white bulb is subscribed to")
+	}
+	if client.receive("homie/desk/bg/bright/set", "42") {
+		t.Errorf("bg/bright/set of a bulb without an ambilight is subscribed to")
+	}
+}
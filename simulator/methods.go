@@ -0,0 +1,415 @@
+package simulator
+
+import (
+	"net"
+	"strconv"
+	"strings"
+)
+
+// method executes a command, it's called with the mutex of the bulb locked.
+// It returns the result, and the properties to change (only if there is no error).
+type method func(b *Bulb, p params) ([]interface{}, map[string]string, *Error)
+
+type params []interface{}
+
+var ok = []interface{}{"ok"}
+
+// int returns the parameter at index i, if it's an integer
+func (p params) int(i int) (int, bool) {
+	if i >= len(p) {
+		return 0, false
+	}
+	num, isNum := p[i].(float64)
+	if !isNum || num != float64(int(num)) {
+		return 0, false
+	}
+	return int(num), true
+}
+
+// string returns the parameter at index i, if it's a string
+func (p params) string(i int) (string, bool) {
+	if i >= len(p) {
+		return "", false
+	}
+	str, isStr := p[i].(string)
+	return str, isStr
+}
+
+// intRange returns the parameter at index i, if it's an integer between min and max
+func (p params) intRange(i int, min int, max int) (int, bool) {
+	num, isNum := p.int(i)
+	return num, isNum && num >= min && num <= max
+}
+
+// transition checks the "effect" and "duration" parameters starting at index i
+func (p params) transition(i int) bool {
+	effect, isStr := p.string(i)
+	if !isStr || (effect != "sudden" && effect != "smooth") {
+		return false
+	}
+	if effect == "sudden" {
+		return true
+	}
+	_, valid := p.intRange(i+1, 30, 1<<31-1)
+	return valid
+}
+
+// flowExpression checks the expression of a color flow, "duration, mode, value, brightness" repeated
+func flowExpression(expression string) bool {
+	fields := strings.Split(expression, ",")
+	if len(fields) == 0 || len(fields)%4 != 0 {
+		return false
+	}
+	for _, field := range fields {
+		_, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+var methods = map[string]method{
+	"get_prop": func(b *Bulb, p params) ([]interface{}, map[string]string, *Error) {
+		result := make([]interface{}, len(p))
+		for k := range p {
+			name, _ := p.string(k)
+			// unknown properties are returned as empty strings, just like real Yeelights do
+			result[k] = b.props[name]
+		}
+		return result, nil, nil
+	},
+
+	"dev_toggle": func(b *Bulb, p params) ([]interface{}, map[string]string, *Error) {
+		return ok, map[string]string{
+			"power":    toggled(b.props["power"]),
+			"bg_power": toggled(b.props["bg_power"]),
+		}, nil
+	},
+
+	"set_name": func(b *Bulb, p params) ([]interface{}, map[string]string, *Error) {
+		name, valid := p.string(0)
+		if !valid {
+			return nil, nil, &ErrInvalidParams
+		}
+		return ok, map[string]string{"name": name}, nil
+	},
+
+	"cron_add": func(b *Bulb, p params) ([]interface{}, map[string]string, *Error) {
+		_, validType := p.intRange(0, 0, 0)
+		minutes, validValue := p.intRange(1, 1, 60*24)
+		if !validType || !validValue {
+			return nil, nil, &ErrInvalidParams
+		}
+		return ok, map[string]string{"delayoff": strconv.Itoa(minutes)}, nil
+	},
+
+	"cron_get": func(b *Bulb, p params) ([]interface{}, map[string]string, *Error) {
+		if _, valid := p.intRange(0, 0, 0); !valid {
+			return nil, nil, &ErrInvalidParams
+		}
+		minutes, _ := strconv.Atoi(b.props["delayoff"])
+		if minutes == 0 {
+			return []interface{}{}, nil, nil
+		}
+		return []interface{}{map[string]int{"type": 0, "delay": minutes, "mix": 0}}, nil, nil
+	},
+
+	"cron_del": func(b *Bulb, p params) ([]interface{}, map[string]string, *Error) {
+		if _, valid := p.intRange(0, 0, 0); !valid {
+			return nil, nil, &ErrInvalidParams
+		}
+		return ok, map[string]string{"delayoff": "0"}, nil
+	},
+}
+
+func init() {
+	methods["set_music"] = setMusic
+
+	// the main light and the ambilight have the same methods, the ones of the ambilight are prefixed with bg_
+	for _, prefix := range []string{"", "bg_"} {
+		prefix := prefix
+		prop := func(name string) string {
+			if prefix == "bg_" && name == "color_mode" {
+				return "bg_lmode"
+			}
+			return prefix + name
+		}
+
+		methods[prefix+"set_power"] = func(b *Bulb, p params) ([]interface{}, map[string]string, *Error) {
+			power, valid := p.string(0)
+			if !valid || (power != "on" && power != "off") || !p.transition(1) {
+				return nil, nil, &ErrInvalidParams
+			}
+
+			props := map[string]string{prop("power"): power}
+			if power == "off" {
+				return ok, props, nil
+			}
+
+			mode := 0
+			if len(p) > 3 {
+				mode, valid = p.intRange(3, 0, 5)
+				if !valid {
+					return nil, nil, &ErrInvalidParams
+				}
+			}
+			switch mode {
+			case 1:
+				props[prop("color_mode")] = "2"
+			case 2:
+				props[prop("color_mode")] = "1"
+			case 3:
+				props[prop("color_mode")] = "3"
+			case 4:
+				props[prop("flowing")] = "1"
+			case 5:
+				if prefix != "" {
+					return nil, nil, &ErrInvalidParams
+				}
+				props["active_mode"] = "1"
+			}
+			if mode >= 1 && mode <= 3 && prefix == "" {
+				props["active_mode"] = "0"
+			}
+			return ok, props, nil
+		}
+
+		methods[prefix+"toggle"] = func(b *Bulb, p params) ([]interface{}, map[string]string, *Error) {
+			return ok, map[string]string{prop("power"): toggled(b.props[prop("power")])}, nil
+		}
+
+		methods[prefix+"set_default"] = func(b *Bulb, p params) ([]interface{}, map[string]string, *Error) {
+			return ok, nil, nil
+		}
+
+		methods[prefix+"set_bright"] = func(b *Bulb, p params) ([]interface{}, map[string]string, *Error) {
+			bright, valid := p.intRange(0, 1, 100)
+			if !valid || !p.transition(1) {
+				return nil, nil, &ErrInvalidParams
+			}
+
+			// in moonlight mode, the brightness of the moonlight is changed instead
+			if prefix == "" && b.props["active_mode"] == "1" {
+				return ok, map[string]string{"nl_br": strconv.Itoa(bright)}, nil
+			}
+			return ok, map[string]string{prop("bright"): strconv.Itoa(bright)}, nil
+		}
+
+		methods[prefix+"set_ct_abx"] = func(b *Bulb, p params) ([]interface{}, map[string]string, *Error) {
+			ct, valid := p.intRange(0, 1700, 6500)
+			if !valid || !p.transition(1) {
+				return nil, nil, &ErrInvalidParams
+			}
+			return ok, map[string]string{prop("ct"): strconv.Itoa(ct), prop("color_mode"): "2", prop("flowing"): "0"}, nil
+		}
+
+		methods[prefix+"set_rgb"] = func(b *Bulb, p params) ([]interface{}, map[string]string, *Error) {
+			rgb, valid := p.intRange(0, 0, 0xFFFFFF)
+			if !valid || !p.transition(1) {
+				return nil, nil, &ErrInvalidParams
+			}
+			return ok, map[string]string{prop("rgb"): strconv.Itoa(rgb), prop("color_mode"): "1", prop("flowing"): "0"}, nil
+		}
+
+		methods[prefix+"set_hsv"] = func(b *Bulb, p params) ([]interface{}, map[string]string, *Error) {
+			hue, validHue := p.intRange(0, 0, 359)
+			sat, validSat := p.intRange(1, 0, 100)
+			if !validHue || !validSat || !p.transition(2) {
+				return nil, nil, &ErrInvalidParams
+			}
+			return ok, map[string]string{
+				prop("hue"):        strconv.Itoa(hue),
+				prop("sat"):        strconv.Itoa(sat),
+				prop("color_mode"): "3",
+				prop("flowing"):    "0",
+			}, nil
+		}
+
+		methods[prefix+"start_cf"] = func(b *Bulb, p params) ([]interface{}, map[string]string, *Error) {
+			count, validCount := p.intRange(0, 0, 1<<31-1)
+			action, validAction := p.intRange(1, 0, 2)
+			expression, validExpression := p.string(2)
+			if !validCount || !validAction || !validExpression || !flowExpression(expression) {
+				return nil, nil, &ErrInvalidParams
+			}
+			return ok, map[string]string{
+				prop("flowing"):     "1",
+				prop("flow_params"): strconv.Itoa(count) + "," + strconv.Itoa(action) + "," + expression,
+			}, nil
+		}
+
+		methods[prefix+"stop_cf"] = func(b *Bulb, p params) ([]interface{}, map[string]string, *Error) {
+			return ok, map[string]string{prop("flowing"): "0"}, nil
+		}
+
+		methods[prefix+"set_scene"] = func(b *Bulb, p params) ([]interface{}, map[string]string, *Error) {
+			class, _ := p.string(0)
+			props := map[string]string{prop("power"): "on"}
+			if prefix == "" {
+				props["active_mode"] = "0"
+			}
+
+			switch class {
+			case "color":
+				rgb, validRGB := p.intRange(1, 0, 0xFFFFFF)
+				bright, validBright := p.intRange(2, 1, 100)
+				if !validRGB || !validBright {
+					return nil, nil, &ErrInvalidParams
+				}
+				props[prop("rgb")] = strconv.Itoa(rgb)
+				props[prop("bright")] = strconv.Itoa(bright)
+				props[prop("color_mode")] = "1"
+			case "hsv":
+				hue, validHue := p.intRange(1, 0, 359)
+				sat, validSat := p.intRange(2, 0, 100)
+				bright, validBright := p.intRange(3, 1, 100)
+				if !validHue || !validSat || !validBright {
+					return nil, nil, &ErrInvalidParams
+				}
+				props[prop("hue")] = strconv.Itoa(hue)
+				props[prop("sat")] = strconv.Itoa(sat)
+				props[prop("bright")] = strconv.Itoa(bright)
+				props[prop("color_mode")] = "3"
+			case "ct":
+				ct, validCt := p.intRange(1, 1700, 6500)
+				bright, validBright := p.intRange(2, 1, 100)
+				if !validCt || !validBright {
+					return nil, nil, &ErrInvalidParams
+				}
+				props[prop("ct")] = strconv.Itoa(ct)
+				props[prop("bright")] = strconv.Itoa(bright)
+				props[prop("color_mode")] = "2"
+			case "cf":
+				count, validCount := p.intRange(1, 0, 1<<31-1)
+				action, validAction := p.intRange(2, 0, 2)
+				expression, validExpression := p.string(3)
+				if !validCount || !validAction || !validExpression || !flowExpression(expression) {
+					return nil, nil, &ErrInvalidParams
+				}
+				props[prop("flowing")] = "1"
+				props[prop("flow_params")] = strconv.Itoa(count) + "," + strconv.Itoa(action) + "," + expression
+				return ok, props, nil
+			case "auto_delay_off":
+				bright, validBright := p.intRange(1, 1, 100)
+				minutes, validMinutes := p.intRange(2, 1, 60*24)
+				if !validBright || !validMinutes || prefix != "" {
+					return nil, nil, &ErrInvalidParams
+				}
+				props["bright"] = strconv.Itoa(bright)
+				props["delayoff"] = strconv.Itoa(minutes)
+			default:
+				return nil, nil, &ErrInvalidParams
+			}
+
+			props[prop("flowing")] = "0"
+			return ok, props, nil
+		}
+
+		methods[prefix+"set_adjust"] = func(b *Bulb, p params) ([]interface{}, map[string]string, *Error) {
+			action, _ := p.string(0)
+			property, _ := p.string(1)
+
+			step := 0
+			switch action {
+			case "increase", "circle":
+				step = 1
+			case "decrease":
+				step = -1
+			default:
+				return nil, nil, &ErrInvalidParams
+			}
+			circle := action == "circle"
+
+			switch property {
+			case "bright":
+				return ok, map[string]string{prop("bright"): adjusted(b.props[prop("bright")], step*10, 1, 100, circle)}, nil
+			case "ct":
+				return ok, map[string]string{prop("ct"): adjusted(b.props[prop("ct")], step*500, 1700, 6500, circle)}, nil
+			case "color":
+				if !circle {
+					return nil, nil, &ErrInvalidParams
+				}
+				return ok, map[string]string{prop("hue"): adjusted(b.props[prop("hue")], 30, 0, 359, true), prop("color_mode"): "3"}, nil
+			}
+			return nil, nil, &ErrInvalidParams
+		}
+
+		methods[prefix+"adjust_bright"] = func(b *Bulb, p params) ([]interface{}, map[string]string, *Error) {
+			percentage, valid := p.intRange(0, -100, 100)
+			if _, validDuration := p.intRange(1, 30, 1<<31-1); !valid || !validDuration {
+				return nil, nil, &ErrInvalidParams
+			}
+			return ok, map[string]string{prop("bright"): adjusted(b.props[prop("bright")], percentage, 1, 100, false)}, nil
+		}
+
+		methods[prefix+"adjust_ct"] = func(b *Bulb, p params) ([]interface{}, map[string]string, *Error) {
+			percentage, valid := p.intRange(0, -100, 100)
+			if _, validDuration := p.intRange(1, 30, 1<<31-1); !valid || !validDuration {
+				return nil, nil, &ErrInvalidParams
+			}
+			step := percentage * (6500 - 1700) / 100
+			return ok, map[string]string{prop("ct"): adjusted(b.props[prop("ct")], step, 1700, 6500, false), prop("color_mode"): "2"}, nil
+		}
+
+		methods[prefix+"adjust_color"] = func(b *Bulb, p params) ([]interface{}, map[string]string, *Error) {
+			percentage, valid := p.intRange(0, -100, 100)
+			if _, validDuration := p.intRange(1, 30, 1<<31-1); !valid || !validDuration {
+				return nil, nil, &ErrInvalidParams
+			}
+			step := percentage * 360 / 100
+			return ok, map[string]string{prop("hue"): adjusted(b.props[prop("hue")], step, 0, 359, true), prop("color_mode"): "3"}, nil
+		}
+	}
+}
+
+// setMusic connects to the music server, it isn't in methods directly since that would be an initialization cycle
+func setMusic(b *Bulb, p params) ([]interface{}, map[string]string, *Error) {
+	action, valid := p.intRange(0, 0, 1)
+	if !valid {
+		return nil, nil, &ErrInvalidParams
+	}
+
+	if action == 0 {
+		if b.music != nil {
+			b.music.conn.Close()
+			b.music = nil
+		}
+		return ok, map[string]string{"music_on": "0"}, nil
+	}
+
+	host, validHost := p.string(1)
+	port, validPort := p.intRange(2, 1, 65535)
+	if !validHost || !validPort {
+		return nil, nil, &ErrInvalidParams
+	}
+	if b.connectMusic(net.JoinHostPort(host, strconv.Itoa(port))) != nil {
+		return nil, nil, &Error{Code: -1, Message: "connect to music server failed"}
+	}
+	return ok, map[string]string{"music_on": "1"}, nil
+}
+
+func toggled(power string) string {
+	if power == "on" {
+		return "off"
+	}
+	return "on"
+}
+
+// adjusted adds step to the integer in value, and either clamps the result to min and max, or wraps it around
+func adjusted(value string, step int, min int, max int, circle bool) string {
+	num, _ := strconv.Atoi(value)
+	num += step
+
+	switch {
+	case circle && num > max:
+		num = min + (num-max-1)%(max-min+1)
+	case circle && num < min:
+		num = max - (min-num-1)%(max-min+1)
+	case num > max:
+		num = max
+	case num < min:
+		num = min
+	}
+	return strconv.Itoa(num)
+}
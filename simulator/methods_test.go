@@ -0,0 +1,128 @@
+package simulator
+
+import (
+	"testing"
+)
+
+func TestMethods(t *testing.T) {
+	tests := []struct {
+		name   string
+		props  map[string]string // set before the command
+		method string
+		params []interface{}
+		want   map[string]string // nil if the params are invalid
+	}{
+		{"power off", nil, "set_power", []interface{}{"off", "smooth", 500}, map[string]string{"power": "off"}},
+		{"power on in ct mode", map[string]string{"power": "off"}, "set_power", []interface{}{"on", "sudden", 0, 1}, map[string]string{"power": "on", "color_mode": "2"}},
+		{"power on in moonlight mode", nil, "set_power", []interface{}{"on", "sudden", 0, 5}, map[string]string{"active_mode": "1"}},
+		{"moonlight mode of the ambilight", nil, "bg_set_power", []interface{}{"on", "sudden", 0, 5}, nil},
+		{"invalid power", nil, "set_power", []interface{}{"maybe", "sudden", 0}, nil},
+		{"too short transition", nil, "set_power", []interface{}{"off", "smooth", 20}, nil},
+		{"toggle", nil, "toggle", nil, map[string]string{"power": "off"}},
+		{"toggle ambilight", nil, "bg_toggle", nil, map[string]string{"bg_power": "on", "power": "on"}},
+		{"dev_toggle", nil, "dev_toggle", nil, map[string]string{"power": "off", "bg_power": "on"}},
+
+		{"bright", nil, "set_bright", []interface{}{42, "sudden", 0}, map[string]string{"bright": "42"}},
+		{"bright of the moonlight", map[string]string{"active_mode": "1"}, "set_bright", []interface{}{42, "sudden", 0}, map[string]string{"nl_br": "42", "bright": "100"}},
+		{"bright 0", nil, "set_bright", []interface{}{0, "sudden", 0}, nil},
+		{"ct", map[string]string{"flowing": "1"}, "set_ct_abx", []interface{}{2700, "sudden", 0}, map[string]string{"ct": "2700", "color_mode": "2", "flowing": "0"}},
+		{"ct out of range", nil, "set_ct_abx", []interface{}{1000, "sudden", 0}, nil},
+		{"rgb", nil, "set_rgb", []interface{}{0x00FF00, "sudden", 0}, map[string]string{"rgb": "65280", "color_mode": "1"}},
+		{"hsv", nil, "bg_set_hsv", []interface{}{120, 50, "sudden", 0}, map[string]string{"bg_hue": "120", "bg_sat": "50", "bg_lmode": "3"}},
+		{"hue out of range", nil, "set_hsv", []interface{}{360, 50, "sudden", 0}, nil},
+
+		{"flow", nil, "start_cf", []interface{}{0, 1, "1000,2,2700,100"}, map[string]string{"flowing": "1", "flow_params": "0,1,1000,2,2700,100"}},
+		{"invalid flow", nil, "start_cf", []interface{}{0, 1, "1000,2,2700"}, nil},
+		{"stop flow", map[string]string{"flowing": "1"}, "stop_cf", nil, map[string]string{"flowing": "0"}},
+
+		{"color scene", map[string]string{"power": "off"}, "set_scene", []interface{}{"color", 0x0000FF, 10}, map[string]string{"power": "on", "rgb": "255", "bright": "10", "color_mode": "1"}},
+		{"ct scene", nil, "bg_set_scene", []interface{}{"ct", 6500, 1}, map[string]string{"bg_power": "on", "bg_ct": "6500", "bg_bright": "1", "bg_lmode": "2"}},
+		{"auto_delay_off scene", nil, "set_scene", []interface{}{"auto_delay_off", 50, 5}, map[string]string{"bright": "50", "delayoff": "5"}},
+		{"unknown scene", nil, "set_scene", []interface{}{"party"}, nil},
+
+		{"set_adjust bright", map[string]string{"bright": "95"}, "set_adjust", []interface{}{"increase", "bright"}, map[string]string{"bright": "100"}},
+		{"set_adjust circle", map[string]string{"bright": "95"}, "set_adjust", []interface{}{"circle", "bright"}, map[string]string{"bright": "5"}},
+		{"set_adjust color", map[string]string{"hue": "350"}, "set_adjust", []interface{}{"circle", "color"}, map[string]string{"hue": "20", "color_mode": "3"}},
+		{"set_adjust increase color", nil, "set_adjust", []interface{}{"increase", "color"}, nil},
+		{"adjust_bright", map[string]string{"bright": "50"}, "adjust_bright", []interface{}{-60, 500}, map[string]string{"bright": "1"}},
+		{"adjust_ct", map[string]string{"bg_ct": "2000"}, "bg_adjust_ct", []interface{}{10, 500}, map[string]string{"bg_ct": "2480", "bg_lmode": "2"}},
+		{"adjust_color", map[string]string{"hue": "300"}, "adjust_color", []interface{}{25, 500}, map[string]string{"hue": "30", "color_mode": "3"}},
+
+		{"name", nil, "set_name", []interface{}{"desk"}, map[string]string{"name": "desk"}},
+		{"cron_add", nil, "cron_add", []interface{}{0, 15}, map[string]string{"delayoff": "15"}},
+		{"cron_del", map[string]string{"delayoff": "15"}, "cron_del", []interface{}{0}, map[string]string{"delayoff": "0"}},
+		{"set_music without the server", nil, "set_music", []interface{}{1}, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			b := newTestBulb(t)
+			b.Change(test.props)
+			before := b.Props()
+
+			resp := dial(t, b).send(test.method, test.params...)
+			if test.want == nil {
+				if resp.Error == nil || *resp.Error != ErrInvalidParams {
+					t.Errorf("%v%v = %+v, want %+v", test.method, test.params, resp.Error, ErrInvalidParams)
+				}
+				for name, value := range b.Props() {
+					if before[name] != value {
+						t.Errorf("%v changed to %v", name, value)
+					}
+				}
+				return
+			}
+
+			if resp.Error != nil {
+				t.Fatalf("%v%v failed: %+v", test.method, test.params, resp.Error)
+			}
+			for name, value := range test.want {
+				if got := b.Prop(name); got != value {
+					t.Errorf("%v = %v, want %v", name, got, value)
+				}
+			}
+		})
+	}
+}
+
+func TestCron(t *testing.T) {
+	b := newTestBulb(t)
+	c := dial(t, b)
+
+	if resp := c.send("cron_get", 0); resp.Error != nil || len(resp.Result) != 0 {
+		t.Errorf("cron_get without a timer = %v (%+v), want nothing", resp.Result, resp.Error)
+	}
+
+	c.send("cron_add", 0, 15)
+	resp := c.send("cron_get", 0)
+	if resp.Error != nil || len(resp.Result) != 1 {
+		t.Fatalf("cron_get = %v (%+v), want one timer", resp.Result, resp.Error)
+	}
+	if timer, _ := resp.Result[0].(map[string]interface{}); timer["delay"] != 15.0 {
+		t.Errorf("cron_get = %v, want a delay of 15", resp.Result)
+	}
+}
+
+func TestAdjusted(t *testing.T) {
+	tests := []struct {
+		value    string
+		step     int
+		min, max int
+		circle   bool
+		want     string
+	}{
+		{"50", 10, 1, 100, false, "60"},
+		{"95", 10, 1, 100, false, "100"},
+		{"5", -10, 1, 100, false, "1"},
+		{"95", 10, 1, 100, true, "5"},
+		{"5", -10, 1, 100, true, "95"},
+		{"350", 30, 0, 359, true, "20"},
+		{"10", -30, 0, 359, true, "340"},
+	}
+
+	for _, test := range tests {
+		if got := adjusted(test.value, test.step, test.min, test.max, test.circle); got != test.want {
+			t.Errorf("adjusted(%v, %v, %v, %v, %v) = %v, want %v", test.value, test.step, test.min, test.max, test.circle, got, test.want)
+		}
+	}
+}
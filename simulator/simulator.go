@@ -0,0 +1,414 @@
+// Package simulator implements a fake Yeelight, which speaks the LAN protocol over TCP and keeps its own state.
+// It's meant for testing the api package (and everything built on top of it) without a real light.
+package simulator
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// commands a real Yeelight accepts per minute on one connection, and across all connections
+	connectionQuota = 60
+	totalQuota      = 144
+	quotaWindow     = time.Minute
+)
+
+// Error is the error object sent in place of the result, e.g. {"code":-1, "message":"method not supported"}
+type Error struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+var (
+	ErrUnsupportedMethod = Error{Code: -1, Message: "method not supported"}
+	ErrInvalidParams     = Error{Code: -1, Message: "invalid params"}
+	ErrQuotaExceeded     = Error{Code: -1, Message: "client quota exceeded"}
+)
+
+// Faults makes the bulb misbehave the way real Yeelights do, see SetFaults
+type Faults struct {
+	// DropConnections is the number of commands that get their connection closed instead of a response
+	DropConnections int
+
+	// LoseResponses is the number of commands that are executed, but get their connection closed instead of a response
+	LoseResponses int
+
+	// Delay is how long the bulb waits before sending a response
+	Delay time.Duration
+
+	// Errors are sent in response to the methods (the keys) instead of executing them, "" matches every method
+	Errors map[string]Error
+
+	// InterleaveNotifications sends a props notification before each response
+	InterleaveNotifications bool
+}
+
+// Bulb is the fake Yeelight, create it with New
+type Bulb struct {
+	// Support is the list of the methods the bulb knows, every method is supported if it's empty
+	Support []string
+
+	// Quota enforces the command quota of a real Yeelight
+	Quota bool
+
+	mutex    sync.Mutex
+	props    map[string]string
+	faults   Faults
+	listener net.Listener
+	clients  map[*client]bool
+	music    *client
+	sent     []time.Time // commands received in the last quotaWindow across all connections
+}
+
+type client struct {
+	conn       net.Conn
+	writeMutex sync.Mutex
+	sent       []time.Time // commands received in the last quotaWindow on this connection
+}
+
+type request struct {
+	ID     uint64        `json:"id"`
+	Method string        `json:"method"`
+	Params []interface{} `json:"params"`
+}
+
+type response struct {
+	ID     uint64        `json:"id"`
+	Result []interface{} `json:"result,omitempty"`
+	Error  *Error        `json:"error,omitempty"`
+}
+
+type notification struct {
+	Method string            `json:"method"`
+	Params map[string]string `json:"params"`
+}
+
+// New returns a color bulb with an ambilight, which is turned on
+func New() *Bulb {
+	return &Bulb{
+		Quota: true,
+		props: map[string]string{
+			"power":          "on",
+			"main_power":     "on",
+			"bright":         "100",
+			"ct":             "4000",
+			"rgb":            "16711680",
+			"hue":            "0",
+			"sat":            "100",
+			"color_mode":     "2",
+			"flowing":        "0",
+			"delayoff":       "0",
+			"flow_params":    "",
+			"music_on":       "0",
+			"name":           "",
+			"bg_power":       "off",
+			"bg_flowing":     "0",
+			"bg_flow_params": "",
+			"bg_ct":          "4000",
+			"bg_lmode":       "2",
+			"bg_bright":      "100",
+			"bg_rgb":         "16711680",
+			"bg_hue":         "0",
+			"bg_sat":         "100",
+			"nl_br":          "0",
+			"active_mode":    "0",
+			"bg_proact":      "0",
+			"lan_ctrl":       "1",
+			"save_state":     "0",
+			"init_power_on":  "1",
+		},
+		clients: make(map[*client]bool),
+	}
+}
+
+// Listen starts accepting connections on address, e.g. 127.0.0.1:0
+func (b *Bulb) Listen(address string) error {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+
+	b.mutex.Lock()
+	b.listener = listener
+	b.mutex.Unlock()
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			c := &client{conn: conn}
+
+			b.mutex.Lock()
+			b.clients[c] = true
+			b.mutex.Unlock()
+
+			go b.serve(c, true)
+		}
+	}()
+
+	return nil
+}
+
+// Addr returns the address the bulb listens on, it can be used as api.Light.Host
+func (b *Bulb) Addr() string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.listener.Addr().String()
+}
+
+// Close stops listening and closes every connection, including the music one
+func (b *Bulb) Close() error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	for c := range b.clients {
+		c.conn.Close()
+	}
+	if b.music != nil {
+		b.music.conn.Close()
+	}
+	return b.listener.Close()
+}
+
+// SetFaults replaces the faults the bulb simulates
+func (b *Bulb) SetFaults(faults Faults) {
+	b.mutex.Lock()
+	b.faults = faults
+	b.mutex.Unlock()
+}
+
+// Prop returns the current value of the property
+func (b *Bulb) Prop(name string) string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.props[name]
+}
+
+// Props returns a copy of every property of the bulb
+func (b *Bulb) Props() map[string]string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	props := make(map[string]string, len(b.props))
+	for name, value := range b.props {
+		props[name] = value
+	}
+	return props
+}
+
+// Change changes the properties as if it was done using a wall switch or the Yeelight app,
+// the clients are notified about it
+func (b *Bulb) Change(props map[string]string) {
+	b.mutex.Lock()
+	changed := b.setLocked(props)
+	b.mutex.Unlock()
+
+	b.notify(changed)
+}
+
+// Connections returns the number of clients connected to the bulb, not counting the music connection
+func (b *Bulb) Connections() int {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return len(b.clients)
+}
+
+// MusicMode reports whether the bulb is connected to a music server
+func (b *Bulb) MusicMode() bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.music != nil
+}
+
+// setLocked changes the properties, and returns the ones that have actually changed
+func (b *Bulb) setLocked(props map[string]string) map[string]string {
+	changed := make(map[string]string)
+	for name, value := range props {
+		if b.props[name] != value {
+			b.props[name] = value
+			changed[name] = value
+		}
+	}
+	return changed
+}
+
+// serve processes the commands received from c, responses are only sent if respond is true (they aren't in music mode)
+func (b *Bulb) serve(c *client, respond bool) {
+	defer func() {
+		c.conn.Close()
+
+		b.mutex.Lock()
+		delete(b.clients, c)
+		if b.music == c {
+			b.music = nil
+			b.props["music_on"] = "0"
+		}
+		b.mutex.Unlock()
+	}()
+
+	scanner := bufio.NewScanner(c.conn)
+	for scanner.Scan() {
+		var req request
+		err := json.Unmarshal(scanner.Bytes(), &req)
+		if err != nil {
+			if respond {
+				c.send(response{Error: &Error{Code: -1, Message: "invalid command"}})
+			}
+			continue
+		}
+
+		resp, changed, drop := b.execute(c, req, respond)
+		if drop {
+			return
+		}
+
+		b.mutex.Lock()
+		faults := b.faults
+		b.mutex.Unlock()
+
+		if respond {
+			time.Sleep(faults.Delay)
+			if faults.InterleaveNotifications {
+				c.send(notification{Method: "props", Params: b.Props()})
+			}
+			c.send(resp)
+		}
+		b.notify(changed)
+	}
+}
+
+// execute runs the command (unless the connection is dropped before that), and returns the response,
+// the changed properties, and whether the connection should be dropped instead of responding
+func (b *Bulb) execute(c *client, req request, checkQuota bool) (response, map[string]string, bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	resp := response{ID: req.ID}
+
+	if b.faults.DropConnections > 0 {
+		b.faults.DropConnections--
+		return resp, nil, true
+	}
+
+	if checkQuota && b.Quota && !b.countLocked(c) {
+		resp.Error = &ErrQuotaExceeded
+		return resp, nil, false
+	}
+
+	for _, method := range []string{req.Method, ""} {
+		if e, ok := b.faults.Errors[method]; ok {
+			resp.Error = &e
+			return resp, nil, false
+		}
+	}
+
+	if !b.supportsLocked(req.Method) {
+		resp.Error = &ErrUnsupportedMethod
+		return resp, nil, false
+	}
+
+	method, ok := methods[req.Method]
+	if !ok {
+		resp.Error = &ErrUnsupportedMethod
+		return resp, nil, false
+	}
+
+	result, props, e := method(b, params(req.Params))
+	if e != nil {
+		resp.Error = e
+		return resp, nil, false
+	}
+	resp.Result = result
+	changed := b.setLocked(props)
+
+	if b.faults.LoseResponses > 0 {
+		b.faults.LoseResponses--
+		return resp, changed, true
+	}
+	return resp, changed, false
+}
+
+// countLocked counts the command towards the quota, returns false if the quota is exceeded
+func (b *Bulb) countLocked(c *client) bool {
+	now := time.Now()
+	prune := func(sent []time.Time) []time.Time {
+		for len(sent) > 0 && now.Sub(sent[0]) >= quotaWindow {
+			sent = sent[1:]
+		}
+		return sent
+	}
+
+	c.sent = prune(c.sent)
+	b.sent = prune(b.sent)
+	if len(c.sent) >= connectionQuota || len(b.sent) >= totalQuota {
+		return false
+	}
+
+	c.sent = append(c.sent, now)
+	b.sent = append(b.sent, now)
+	return true
+}
+
+func (b *Bulb) supportsLocked(method string) bool {
+	if len(b.Support) == 0 {
+		return true
+	}
+	for _, supported := range b.Support {
+		if supported == method {
+			return true
+		}
+	}
+	return false
+}
+
+// notify sends the changed properties to every client
+func (b *Bulb) notify(changed map[string]string) {
+	if len(changed) == 0 {
+		return
+	}
+
+	b.mutex.Lock()
+	clients := make([]*client, 0, len(b.clients))
+	for c := range b.clients {
+		clients = append(clients, c)
+	}
+	b.mutex.Unlock()
+
+	for _, c := range clients {
+		c.send(notification{Method: "props", Params: changed})
+	}
+}
+
+// connectMusic connects to the music server, the commands received from it are executed without a response
+func (b *Bulb) connectMusic(address string) error {
+	conn, err := net.DialTimeout("tcp", address, 5*time.Second)
+	if err != nil {
+		return err
+	}
+
+	c := &client{conn: conn}
+	if b.music != nil {
+		b.music.conn.Close()
+	}
+	b.music = c
+
+	go b.serve(c, false)
+	return nil
+}
+
+func (c *client) send(msg interface{}) {
+	line, err := json.Marshal(msg)
+	if err != nil {
+		return
+	}
+
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+	_, _ = c.conn.Write(append(line, '\r', '\n'))
+}
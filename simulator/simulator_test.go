@@ -0,0 +1,320 @@
+package simulator
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+)
+
+// testClient talks to the bulb the way a controller does
+type testClient struct {
+	t      *testing.T
+	conn   net.Conn
+	reader *bufio.Reader
+	id     uint64
+}
+
+// newTestBulb starts a bulb listening on a random port, it's closed when the test ends
+func newTestBulb(t *testing.T) *Bulb {
+	t.Helper()
+
+	b := New()
+	if err := b.Listen("127.0.0.1:0"); err != nil {
+		t.Fatalf("Listen() failed: %v", err)
+	}
+	t.Cleanup(func() { b.Close() })
+	return b
+}
+
+func dial(t *testing.T, b *Bulb) *testClient {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", b.Addr())
+	if err != nil {
+		t.Fatalf("Dial() failed: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &testClient{t: t, conn: conn, reader: bufio.NewReader(conn)}
+}
+
+// send sends the command and returns its response, the notifications received in the meantime are skipped
+func (c *testClient) send(method string, params ...interface{}) response {
+	c.t.Helper()
+
+	c.id++
+	line, _ := json.Marshal(request{ID: c.id, Method: method, Params: append([]interface{}{}, params...)})
+	if _, err := c.conn.Write(append(line, '\r', '\n')); err != nil {
+		c.t.Fatalf("Write() failed: %v", err)
+	}
+
+	for {
+		msg, err := c.read()
+		if err != nil {
+			c.t.Fatalf("%v: no response: %v", method, err)
+		}
+		if _, isNotification := msg["method"]; isNotification {
+			continue
+		}
+
+		var resp response
+		raw, _ := json.Marshal(msg)
+		_ = json.Unmarshal(raw, &resp)
+		if resp.ID != c.id {
+			c.t.Fatalf("%v: response has id %v, want %v", method, resp.ID, c.id)
+		}
+		return resp
+	}
+}
+
+// read returns the next line sent by the bulb
+func (c *testClient) read() (map[string]interface{}, error) {
+	_ = c.conn.SetReadDeadline(time.Now().Add(time.Second))
+	line, err := c.reader.ReadBytes('\n')
+	if err != nil {
+		return nil, err
+	}
+
+	var msg map[string]interface{}
+	err = json.Unmarshal(line, &msg)
+	return msg, err
+}
+
+// notification returns the properties of the next props notification
+func (c *testClient) notification() map[string]interface{} {
+	c.t.Helper()
+
+	for {
+		msg, err := c.read()
+		if err != nil {
+			c.t.Fatalf("no notification: %v", err)
+		}
+		if msg["method"] == "props" {
+			params, _ := msg["params"].(map[string]interface{})
+			return params
+		}
+	}
+}
+
+func TestGetProp(t *testing.T) {
+	b := newTestBulb(t)
+	c := dial(t, b)
+
+	resp := c.send("get_prop", "power", "bright", "unknown")
+	if resp.Error != nil {
+		t.Fatalf("get_prop failed: %+v", resp.Error)
+	}
+	want := []interface{}{"on", "100", ""}
+	if len(resp.Result) != len(want) {
+		t.Fatalf("get_prop = %v, want %v", resp.Result, want)
+	}
+	for k := range want {
+		if resp.Result[k] != want[k] {
+			t.Errorf("get_prop = %v, want %v", resp.Result, want)
+		}
+	}
+}
+
+func TestNotifications(t *testing.T) {
+	b := newTestBulb(t)
+	sender := dial(t, b)
+	other := dial(t, b)
+
+	// every client is notified about the change, including the one that made it
+	if resp := sender.send("set_bright", 50, "sudden", 0); resp.Error != nil {
+		t.Fatalf("set_bright failed: %+v", resp.Error)
+	}
+	if props := other.notification(); props["bright"] != "50" {
+		t.Errorf("notification = %v, want bright 50", props)
+	}
+
+	// the changes made on the bulb itself are notified too, only the changed properties are sent
+	b.Change(map[string]string{"power": "off", "bright": "50"})
+	props := other.notification()
+	if props["power"] != "off" || len(props) != 1 {
+		t.Errorf("notification = %v, want only power off", props)
+	}
+	if b.Prop("power") != "off" {
+		t.Errorf("power = %v after Change(), want off", b.Prop("power"))
+	}
+}
+
+func TestUnsupportedMethod(t *testing.T) {
+	b := newTestBulb(t)
+	b.Support = []string{"get_prop", "set_power"}
+	c := dial(t, b)
+
+	if resp := c.send("set_bright", 50, "sudden", 0); resp.Error == nil || *resp.Error != ErrUnsupportedMethod {
+		t.Errorf("set_bright on a bulb without it = %+v, want %+v", resp.Error, ErrUnsupportedMethod)
+	}
+	if resp := c.send("set_power", "off", "sudden", 0); resp.Error != nil {
+		t.Errorf("set_power failed: %+v", resp.Error)
+	}
+	if resp := c.send("no_such_method"); resp.Error == nil || *resp.Error != ErrUnsupportedMethod {
+		t.Errorf("no_such_method = %+v, want %+v", resp.Error, ErrUnsupportedMethod)
+	}
+}
+
+func TestQuota(t *testing.T) {
+	b := newTestBulb(t)
+	c := dial(t, b)
+
+	for k := 0; k < connectionQuota; k++ {
+		if resp := c.send("get_prop", "power"); resp.Error != nil {
+			t.Fatalf("command %v failed: %+v", k+1, resp.Error)
+		}
+	}
+	if resp := c.send("get_prop", "power"); resp.Error == nil || *resp.Error != ErrQuotaExceeded {
+		t.Errorf("command over the quota = %+v, want %+v", resp.Error, ErrQuotaExceeded)
+	}
+
+	// a new connection has a quota of its own, until the total quota is exceeded
+	sent := connectionQuota
+	for sent < totalQuota {
+		c = dial(t, b)
+		for k := 0; k < connectionQuota && sent < totalQuota; k++ {
+			if resp := c.send("get_prop", "power"); resp.Error != nil {
+				t.Fatalf("command %v failed: %+v", sent+1, resp.Error)
+			}
+			sent++
+		}
+	}
+	c = dial(t, b)
+	if resp := c.send("get_prop", "power"); resp.Error == nil || *resp.Error != ErrQuotaExceeded {
+		t.Errorf("command over the total quota = %+v, want %+v", resp.Error, ErrQuotaExceeded)
+	}
+
+	// the quota can be turned off
+	b.Quota = false
+	if resp := c.send("get_prop", "power"); resp.Error != nil {
+		t.Errorf("command without the quota failed: %+v", resp.Error)
+	}
+}
+
+func TestFaults(t *testing.T) {
+	t.Run("dropped connection", func(t *testing.T) {
+		b := newTestBulb(t)
+		b.SetFaults(Faults{DropConnections: 1})
+		c := dial(t, b)
+
+		c.id++
+		line, _ := json.Marshal(request{ID: c.id, Method: "set_power", Params: []interface{}{"off", "sudden", 0}})
+		_, _ = c.conn.Write(append(line, '\r', '\n'))
+		if msg, err := c.read(); err == nil {
+			t.Fatalf("got %v, want the connection closed", msg)
+		}
+		if b.Prop("power") != "on" {
+			t.Errorf("the command of the dropped connection was executed")
+		}
+
+		// only the given number of commands is dropped
+		if resp := dial(t, b).send("set_power", "off", "sudden", 0); resp.Error != nil {
+			t.Errorf("set_power failed: %+v", resp.Error)
+		}
+	})
+
+	t.Run("lost response", func(t *testing.T) {
+		b := newTestBulb(t)
+		b.SetFaults(Faults{LoseResponses: 1})
+		c := dial(t, b)
+
+		c.id++
+		line, _ := json.Marshal(request{ID: c.id, Method: "set_power", Params: []interface{}{"off", "sudden", 0}})
+		_, _ = c.conn.Write(append(line, '\r', '\n'))
+		if msg, err := c.read(); err == nil {
+			t.Fatalf("got %v, want the connection closed", msg)
+		}
+		if b.Prop("power") != "off" {
+			t.Errorf("the command of the lost response wasn't executed")
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		b := newTestBulb(t)
+		e := Error{Code: -5000, Message: "general error"}
+		b.SetFaults(Faults{Errors: map[string]Error{"set_power": e}})
+		c := dial(t, b)
+
+		if resp := c.send("set_power", "off", "sudden", 0); resp.Error == nil || *resp.Error != e {
+			t.Errorf("set_power = %+v, want %+v", resp.Error, e)
+		}
+		if b.Prop("power") != "on" {
+			t.Errorf("the command responded to with an error was executed")
+		}
+		if resp := c.send("get_prop", "power"); resp.Error != nil {
+			t.Errorf("get_prop failed: %+v", resp.Error)
+		}
+
+		b.SetFaults(Faults{Errors: map[string]Error{"": e}})
+		if resp := c.send("get_prop", "power"); resp.Error == nil || *resp.Error != e {
+			t.Errorf("get_prop = %+v, want %+v", resp.Error, e)
+		}
+	})
+
+	t.Run("delay", func(t *testing.T) {
+		b := newTestBulb(t)
+		b.SetFaults(Faults{Delay: 100 * time.Millisecond})
+		c := dial(t, b)
+
+		start := time.Now()
+		c.send("get_prop", "power")
+		if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+			t.Errorf("response came after %v, want at least 100ms", elapsed)
+		}
+	})
+
+	t.Run("interleaved notifications", func(t *testing.T) {
+		b := newTestBulb(t)
+		b.SetFaults(Faults{InterleaveNotifications: true})
+		c := dial(t, b)
+
+		c.id++
+		line, _ := json.Marshal(request{ID: c.id, Method: "get_prop", Params: []interface{}{"power"}})
+		_, _ = c.conn.Write(append(line, '\r', '\n'))
+		if msg, err := c.read(); err != nil || msg["method"] != "props" {
+			t.Errorf("got %v (%v), want a notification before the response", msg, err)
+		}
+	})
+}
+
+func TestMusicMode(t *testing.T) {
+	b := newTestBulb(t)
+	c := dial(t, b)
+
+	server, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() failed: %v", err)
+	}
+	defer server.Close()
+	port := server.Addr().(*net.TCPAddr).Port
+
+	if resp := c.send("set_music", 1, "127.0.0.1", port); resp.Error != nil {
+		t.Fatalf("set_music failed: %+v", resp.Error)
+	}
+	if props := c.notification(); props["music_on"] != "1" {
+		t.Errorf("notification = %v, want music_on 1", props)
+	}
+	conn, err := server.Accept()
+	if err != nil {
+		t.Fatalf("Accept() failed: %v", err)
+	}
+	defer conn.Close()
+	if !b.MusicMode() || b.Prop("music_on") != "1" {
+		t.Fatalf("bulb isn't in music mode")
+	}
+
+	// the commands sent on the music connection are executed without a response
+	line, _ := json.Marshal(request{ID: 1, Method: "set_bright", Params: []interface{}{30, "sudden", 0}})
+	_, _ = conn.Write(append(line, '\r', '\n'))
+	if props := c.notification(); props["bright"] != "30" {
+		t.Errorf("notification = %v, want bright 30", props)
+	}
+
+	if resp := c.send("set_music", 0); resp.Error != nil {
+		t.Fatalf("set_music failed: %+v", resp.Error)
+	}
+	if b.MusicMode() || b.Prop("music_on") != "0" {
+		t.Errorf("bulb is still in music mode")
+	}
+}
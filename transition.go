@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/dsorm/yeelight2mqtt/api"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"strings"
+)
+
+// key of the transition of a single message in the context of its handler
+type transitionKey struct{}
+
+/*
+cutTransition splits the transition off the payload of a message, e.g.
+
+	50?transition=3s
+	true?transition=sudden
+
+The transition is written as in api.ParseTransition. It returns nil if the payload has no transition.
+*/
+func cutTransition(payload string) (string, *api.Transition, error) {
+	value, suffix, found := strings.Cut(payload, "?transition=")
+	if !found {
+		return payload, nil, nil
+	}
+
+	transition, err := api.ParseTransition(suffix)
+	if err != nil {
+		return "", nil, err
+	}
+	return value, &transition, nil
+}
+
+// transition returns the transition of the commands sent to the light: the one of the message (as cut by
+// cutTransition), the one set using the main/transition property, the configured one, or api.DefaultTransition
+func (as *AppState) transition(ctx context.Context, light *api.Light) api.Transition {
+	if transition, ok := ctx.Value(transitionKey{}).(api.Transition); ok {
+		return transition
+	}
+	return as.lightTransition(light)
+}
+
+// lightTransition is like transition, but it leaves out the transition of the message
+func (as *AppState) lightTransition(light *api.Light) api.Transition {
+	if transition, ok := as.transitions.Load(light); ok {
+		return transition.(api.Transition)
+	}
+	if light.Transition != (api.Transition{}) {
+		return light.Transition
+	}
+	return api.DefaultTransition
+}
+
+// Value of the main/transition property, the duration in milliseconds, 0 if the transition is sudden
+func transitionValue(transition api.Transition) string {
+	if transition.Effect == "sudden" {
+		return "0"
+	}
+	return fmt.Sprintf("%v", transition.Duration)
+}
+
+// transitionMessage is a message whose payload had its transition cut off
+type transitionMessage struct {
+	mqtt.Message
+	payload []byte
+}
+
+func (m transitionMessage) Payload() []byte {
+	return m.payload
+}